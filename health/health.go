@@ -2,6 +2,7 @@ package health
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -156,7 +157,9 @@ func (s *Server) Stop() {
 
 type Checkable func(context.Context) error
 
-// GoCheck is a helper to run multiple check functions in parallel and fail if one fails
+// GoCheck is a helper to run multiple check functions in parallel and fail if one fails.
+// Errors with a NonFatal method returning true, reporting a degraded dependency that
+// keeps working, are logged instead.
 func GoCheck(ctx context.Context, toCheck ...Checkable) error {
 	ch := make(chan error, len(toCheck))
 	for _, f := range toCheck {
@@ -166,6 +169,11 @@ func GoCheck(ctx context.Context, toCheck ...Checkable) error {
 	}
 	for range toCheck {
 		if err := <-ch; err != nil {
+			var nf interface{ NonFatal() bool }
+			if errors.As(err, &nf) && nf.NonFatal() {
+				log.Warn().Err(err).Msg("healthcheck degraded")
+				continue
+			}
 			return err
 		}
 	}
@@ -3,12 +3,14 @@ module github.com/ggsrc/gglib/interceptor
 go 1.24.7
 
 require (
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/bytedance/sonic v1.12.3
 	github.com/getsentry/sentry-go v0.35.3
 	github.com/ggsrc/gglib/mctx v0.0.0-20251126145614-15e1b11ff84e
 	github.com/ggsrc/gglib/zerolog v0.0.0-20251126145614-15e1b11ff84e
 	github.com/jinzhu/copier v0.4.0
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/redis/go-redis/v9 v9.14.0
 	github.com/stretchr/testify v1.11.1
 	go.opentelemetry.io/otel v1.38.0
 	go.opentelemetry.io/otel/trace v1.38.0
@@ -19,9 +21,11 @@ require (
 
 require (
 	github.com/bytedance/sonic/loader v0.2.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/cloudwego/base64x v0.1.4 // indirect
 	github.com/cloudwego/iasm v0.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
@@ -31,6 +35,7 @@ require (
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
 	github.com/rs/zerolog v1.34.0 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	go.uber.org/atomic v1.10.0 // indirect
 	golang.org/x/arch v0.4.0 // indirect
 	golang.org/x/net v0.44.0 // indirect
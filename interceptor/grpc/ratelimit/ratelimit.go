@@ -2,6 +2,7 @@ package ratelimit
 
 import (
 	"context"
+	"reflect"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -10,9 +11,16 @@ import (
 
 const rateLimitErrMsg = "rate limit exceeded"
 
-func RateLimitUnaryServerInterceptor(manager *RateLimitManager) grpc.UnaryServerInterceptor {
-	if manager == nil {
-		panic("RateLimitManager cannot be nil")
+// Limiter decides whether a call to method may proceed, waiting for a slot
+// at most until its own timeout. It is implemented by RateLimitManager
+// (per-process) and RedisRateLimitManager (global across replicas).
+type Limiter interface {
+	Allow(ctx context.Context, method string) bool
+}
+
+func RateLimitUnaryServerInterceptor(manager Limiter) grpc.UnaryServerInterceptor {
+	if isNil(manager) {
+		panic("Limiter cannot be nil")
 	}
 
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -24,9 +32,9 @@ func RateLimitUnaryServerInterceptor(manager *RateLimitManager) grpc.UnaryServer
 	}
 }
 
-func RateLimitUnaryClientInterceptor(manager *RateLimitManager) grpc.UnaryClientInterceptor {
-	if manager == nil {
-		panic("RateLimitManager cannot be nil")
+func RateLimitUnaryClientInterceptor(manager Limiter) grpc.UnaryClientInterceptor {
+	if isNil(manager) {
+		panic("Limiter cannot be nil")
 	}
 
 	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
@@ -41,3 +49,16 @@ func RateLimitUnaryClientInterceptor(manager *RateLimitManager) grpc.UnaryClient
 		return err
 	}
 }
+
+// isNil reports whether l is nil, including a nil pointer in a non-nil
+// interface such as a nil *RedisRateLimitManager.
+func isNil(l Limiter) bool {
+	if l == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(l); v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Func, reflect.Chan, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+const (
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmGCRA          = "gcra"
+)
+
+// slidingWindowScript keeps a sorted set of the timestamps (µs) of the calls
+// allowed within the window.
+// KEYS[1]: key, ARGV[1]: window (µs), ARGV[2]: limit, ARGV[3]: unique member.
+// Returns {allowed, retry after (µs)}.
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[2]) then
+  redis.call('ZADD', KEYS[1], string.format('%d', now), ARGV[3])
+  redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+  return {1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, tonumber(oldest[2]) + window - now}
+`)
+
+// gcraScript implements the generic cell rate algorithm: the key holds the
+// theoretical arrival time (µs) of the next call.
+// KEYS[1]: key, ARGV[1]: emission interval (µs), ARGV[2]: burst.
+// Returns {allowed, retry after (µs)}.
+var gcraScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+  tat = now
+end
+local newTat = tat + interval
+local allowAt = newTat - interval * tonumber(ARGV[2])
+if now < allowAt then
+  return {0, allowAt - now}
+end
+redis.call('SET', KEYS[1], string.format('%d', newTat), 'PX', math.ceil((newTat - now) / 1000))
+return {1, 0}
+`)
+
+// RedisClientProvider returns the Redis client used by RedisRateLimitManager.
+// It is resolved on every call, so *cache.Cache of gglib/resource/cache can
+// be passed before its Init.
+type RedisClientProvider interface {
+	GetRedisClient() redis.UniversalClient
+}
+
+type RedisLimitConfig struct {
+	MethodCapacity map[string]int `required:"true"` // allowed calls per Window
+	Timeout        time.Duration  `default:"500ms"` // max wait for a slot, 0 waits until the call ctx is done
+	Algorithm      string         `default:"gcra"` // gcra or sliding_window
+	Window         time.Duration  `default:"1s"`
+	Burst          int            `default:"10"` // gcra only
+	KeyPrefix      string         `default:"ratelimit"`
+	FailOpen       bool           `default:"true"` // allow calls when redis fails
+}
+
+// RedisRateLimitManager enforces per-method limits shared by every replica
+// through Lua scripts on Redis.
+type RedisRateLimitManager struct {
+	provider RedisClientProvider
+	conf     *RedisLimitConfig
+}
+
+func NewRedisRateLimitManagerWithDefaultEnvPrefix(provider RedisClientProvider) *RedisRateLimitManager {
+	return NewRedisRateLimitManager(provider, "ratelimit")
+}
+
+func NewRedisRateLimitManager(provider RedisClientProvider, envPrefix string) *RedisRateLimitManager {
+	conf := &RedisLimitConfig{}
+	envconfig.MustProcess(envPrefix, conf)
+	return NewRedisRateLimitManagerWithConfig(provider, conf)
+}
+
+func NewRedisRateLimitManagerWithConfig(provider RedisClientProvider, conf *RedisLimitConfig) *RedisRateLimitManager {
+	if provider == nil || conf == nil {
+		panic("provider and conf cannot be nil")
+	}
+	if conf.Algorithm != AlgorithmGCRA && conf.Algorithm != AlgorithmSlidingWindow {
+		panic("unknown rate limit algorithm: " + conf.Algorithm)
+	}
+	return &RedisRateLimitManager{provider: provider, conf: conf}
+}
+
+// Allow reports whether a call to method fits in its global limit, waiting
+// up to the configured timeout for a slot. Methods without a capacity are
+// not limited.
+func (rlm *RedisRateLimitManager) Allow(ctx context.Context, method string) bool {
+	capacity, ok := rlm.conf.MethodCapacity[method]
+	if !ok {
+		return true
+	}
+
+	if rlm.conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rlm.conf.Timeout)
+		defer cancel()
+	}
+	for {
+		allowed, retryAfter, err := rlm.take(ctx, method, capacity)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			log.Ctx(ctx).Err(err).Msgf("failed to check rate limit of %s", method)
+			return rlm.conf.FailOpen
+		}
+		if allowed {
+			return true
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < retryAfter {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+func (rlm *RedisRateLimitManager) take(ctx context.Context, method string, capacity int) (bool, time.Duration, error) {
+	client := rlm.provider.GetRedisClient()
+	if client == nil {
+		return false, 0, redis.ErrClosed
+	}
+	key := rlm.conf.KeyPrefix + ":" + rlm.conf.Algorithm + ":{" + method + "}"
+	window := rlm.conf.Window.Microseconds()
+
+	var res []int64
+	var err error
+	if rlm.conf.Algorithm == AlgorithmSlidingWindow {
+		// nolint: gosec
+		member := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+		res, err = slidingWindowScript.Run(ctx, client, []string{key}, window, capacity, member).Int64Slice()
+	} else {
+		interval := max(window/int64(max(capacity, 1)), 1)
+		res, err = gcraScript.Run(ctx, client, []string{key}, interval, max(rlm.conf.Burst, 1)).Int64Slice()
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Microsecond, nil
+}
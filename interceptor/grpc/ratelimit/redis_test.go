@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticProvider struct {
+	client redis.UniversalClient
+}
+
+func (p staticProvider) GetRedisClient() redis.UniversalClient {
+	return p.client
+}
+
+func newTestRedisManager(t *testing.T, algorithm string) *RedisRateLimitManager {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisRateLimitManagerWithConfig(staticProvider{client: client}, &RedisLimitConfig{
+		MethodCapacity: map[string]int{"/svc/Limited": 3},
+		Timeout:        100 * time.Millisecond,
+		Algorithm:      algorithm,
+		Window:         time.Hour,
+		Burst:          3,
+		KeyPrefix:      "ratelimit",
+		FailOpen:       true,
+	})
+}
+
+func TestRedisRateLimitManager_Allow(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmGCRA, AlgorithmSlidingWindow} {
+		t.Run(algorithm, func(t *testing.T) {
+			rlm := newTestRedisManager(t, algorithm)
+			ctx := context.Background()
+
+			for i := range 3 {
+				assert.True(t, rlm.Allow(ctx, "/svc/Limited"), "call %d should be allowed", i)
+			}
+			assert.False(t, rlm.Allow(ctx, "/svc/Limited"), "call over capacity should be rejected")
+			assert.True(t, rlm.Allow(ctx, "/svc/Unlimited"), "methods without capacity are not limited")
+		})
+	}
+}
+
+func TestRedisRateLimitManager_FailOpen(t *testing.T) {
+	rlm := newTestRedisManager(t, AlgorithmGCRA)
+	rlm.provider = staticProvider{client: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})}
+	rlm.conf.Timeout = time.Second
+
+	assert.True(t, rlm.Allow(context.Background(), "/svc/Limited"))
+
+	rlm.conf.FailOpen = false
+	assert.False(t, rlm.Allow(context.Background(), "/svc/Limited"))
+}
+
+func TestRedisRateLimitManager_ZeroTimeoutWaitsForCallContext(t *testing.T) {
+	rlm := newTestRedisManager(t, AlgorithmGCRA)
+	rlm.conf.Timeout = 0
+	ctx := context.Background()
+
+	assert.True(t, rlm.Allow(ctx, "/svc/Limited"), "a zero timeout should not reject calls within capacity")
+	for range 2 {
+		rlm.Allow(ctx, "/svc/Limited")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, rlm.Allow(ctx, "/svc/Limited"), "call over capacity should be rejected at the ctx deadline")
+}
+
+func TestRateLimitUnaryServerInterceptor_TypedNil(t *testing.T) {
+	var rlm *RedisRateLimitManager
+	assert.PanicsWithValue(t, "Limiter cannot be nil", func() {
+		RateLimitUnaryServerInterceptor(rlm)
+	})
+	assert.PanicsWithValue(t, "Limiter cannot be nil", func() {
+		RateLimitUnaryClientInterceptor(rlm)
+	})
+}
@@ -66,13 +66,15 @@ func ContextWithAppCtx(ctx context.Context, value *AppContext) context.Context {
 	return context.WithValue(ctx, appCtxKey, value)
 }
 
+// StringToAppCtx decodes the app context propagated as app-ctx-bin. Now is
+// set to the current time if unset, when there are common params.
 func StringToAppCtx(s string) (*AppContext, error) {
 	var appCtx AppContext
 	err := sonic.UnmarshalString(s, &appCtx)
 	if err != nil {
 		return nil, err
 	}
-	if appCtx.CommonParams.Now.Unix() == 0 {
+	if appCtx.CommonParams != nil && appCtx.CommonParams.Now.Unix() == 0 {
 		appCtx.CommonParams.Now = time.Now()
 	}
 	return &appCtx, nil
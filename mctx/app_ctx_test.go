@@ -0,0 +1,28 @@
+package mctx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStringToAppCtx(t *testing.T) {
+	appCtx, err := StringToAppCtx(`{"user":{},"common_params":{"aid":7,"now":"1970-01-01T00:00:00Z"}}`)
+	if err != nil {
+		t.Fatalf("StringToAppCtx failed: %v", err)
+	}
+	if appCtx.CommonParams.AppID != 7 || time.Since(appCtx.CommonParams.Now) > time.Minute {
+		t.Errorf("Expected app 7 with Now set to the current time, got %+v", appCtx.CommonParams)
+	}
+
+	appCtx, err = StringToAppCtx(`{"user":{}}`)
+	if err != nil {
+		t.Fatalf("StringToAppCtx failed: %v", err)
+	}
+	if appCtx.CommonParams != nil {
+		t.Errorf("Expected no common params, got %+v", appCtx.CommonParams)
+	}
+
+	if _, err = StringToAppCtx("not json"); err == nil {
+		t.Errorf("Expected a decoding error")
+	}
+}
@@ -2,26 +2,61 @@ package cache
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/coocood/freecache"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
 	"github.com/stumble/dcache"
 )
 
 type Cache struct {
 	initialized  bool
+	available    atomic.Bool
 	appName      string
+	name         string
 	redisClient  redis.UniversalClient
 	dCache       *dcache.DCache
+	memCache     *freecache.Cache
+	bus          *invalidationBus
+	collector    *statsCollector
 	redisConfig  *RedisConfig
 	dCacheConfig *DCacheConfig
+	now          func() time.Time
+
+	// mu guards redisClient, dCache and bus, which are replaced when the
+	// sentinel addresses change in failover mode.
+	mu            sync.RWMutex
+	sentinelAddrs []string
+	watchCancel   context.CancelFunc
+	watchWG       sync.WaitGroup
+
+	bgMu     sync.Mutex
+	bgWG     sync.WaitGroup
+	stopping bool
 }
 
 func NewCacheWithDefaultEnvPrefix(appName string) *Cache {
 	return NewCache(appName, "redis", "dcache")
 }
 
+// NewNamedCache creates the cache called name, configured from the
+// REDIS_<NAME>_ and DCACHE_<NAME>_ environment variables. Named caches of an
+// app have distinct resource names, metric labels and keys, so that several
+// of them can be registered in the same ResourceManager. The dcache metrics
+// are global: Init fails if another cache exports them, unless EnableStats
+// (DCACHE_<NAME>_ENABLESTATS) is false.
+func NewNamedCache(appName string, name string) *Cache {
+	c := NewCache(appName, "redis_"+name, "dcache_"+name)
+	c.name = name
+	return c
+}
+
 func NewCache(appName string, redisEnvPrefix string, dcacheEnvPrefix string) *Cache {
 	redisCfg := RedisConfig{}
 	envconfig.MustProcess(redisEnvPrefix, &redisCfg)
@@ -58,16 +93,55 @@ func NewCacheWithOptions(opts ...Option) *Cache {
 }
 
 func (c *Cache) Name() string {
+	if c.name != "" {
+		return "cache_" + c.name
+	}
 	return "cache"
 }
 
-func (c *Cache) Init(ctx context.Context) error {
-	c.redisClient = c.newRedisClientWithConfig()
-	dCache, err := c.newDCacheWithConfig()
+func (c *Cache) Init(ctx context.Context) (err error) {
+	if c.redisClient == nil {
+		if c.redisClient, err = c.newRedisClientWithConfig(ctx); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			_ = c.redisClient.Close()
+			c.redisClient = nil
+			c.releaseDCacheStats()
+		}
+	}()
+	if err = c.connectRedis(ctx); err != nil {
+		if !c.redisConfig.AllowUnavailable {
+			return err
+		}
+		log.Ctx(ctx).Error().Err(err).Msg("redis is unavailable, cache starts in degraded mode")
+	}
+	c.available.Store(err == nil)
+
+	if err = c.claimDCacheStats(); err != nil {
+		return err
+	}
+	dCache, err := c.newDCacheWithConfig(c.redisClient)
 	if err != nil {
 		return err
 	}
 	c.dCache = dCache
+	if c.dCacheConfig.InvalidationMode != "" && c.dCacheConfig.InvalidationMode != InvalidationModeDisabled {
+		bus := newInvalidationBus(c)
+		if err = bus.start(); err != nil {
+			c.dCache.Close()
+			c.dCache = nil
+			return err
+		}
+		c.bus = bus
+	}
+	c.collector = newStatsCollector(c)
+	if err = prometheus.Register(c.collector); err != nil {
+		log.Ctx(ctx).Err(err).Msg("failed to register cache stats collector")
+	}
+	c.startSentinelWatch()
 	c.initialized = true
 	return nil
 }
@@ -80,11 +154,22 @@ func (c *Cache) Start(ctx context.Context) error {
 }
 
 func (c *Cache) Stop(ctx context.Context) error {
-	if c.dCache != nil {
-		c.dCache.Close()
+	c.stopSentinelWatch()
+	if err := c.waitBackground(ctx); err != nil {
+		return err
+	}
+	if c.collector != nil {
+		prometheus.Unregister(c.collector)
+	}
+	if bus := c.getBus(); bus != nil {
+		bus.stop()
 	}
-	if c.redisClient != nil {
-		err := c.redisClient.Close()
+	if dCache := c.GetDCache(); dCache != nil {
+		dCache.Close()
+	}
+	c.releaseDCacheStats()
+	if rdb := c.GetRedisClient(); rdb != nil {
+		err := rdb.Close()
 		if err != nil {
 			return err
 		}
@@ -93,19 +178,81 @@ func (c *Cache) Stop(ctx context.Context) error {
 }
 
 func (c *Cache) OK(ctx context.Context) error {
-	if c.dCache != nil {
-		err := c.dCache.Ping(ctx)
-		if err != nil {
-			return errors.Wrap(err, "dcache health check failed")
+	if c.GetRedisClient() == nil {
+		return nil
+	}
+	err := c.pingRedis(ctx)
+	c.available.Store(err == nil)
+	if err != nil {
+		if c.redisConfig.AllowUnavailable {
+			log.Ctx(ctx).Warn().Err(err).Msg("redis is unavailable, cache runs in degraded mode")
+			return nil
+		}
+		return errors.Wrap(err, "redis health check failed")
+	}
+	return nil
+}
+
+// pingRedis pings every node in cluster mode and the current master in
+// failover mode.
+func (c *Cache) pingRedis(ctx context.Context) error {
+	rdb := c.GetRedisClient()
+	if cluster, ok := rdb.(*redis.ClusterClient); ok {
+		return cluster.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return errors.Wrapf(shard.Ping(ctx).Err(), "redis node %s", shard.Options().Addr)
+		})
+	}
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		if c.redisConfig.IsFailover {
+			return errors.Wrapf(err, "redis master %s", c.redisConfig.SentinelMasterName)
 		}
+		return err
 	}
 	return nil
 }
 
+// Available reports whether Redis answered the last connection attempt or
+// health check. It is false while the cache runs in degraded mode.
+func (c *Cache) Available() bool {
+	return c.available.Load()
+}
+
+func (c *Cache) AppName() string {
+	return c.appName
+}
+
+// Namespace prefixes every key of the cache: the app name, followed by the
+// cache name for named caches.
+func (c *Cache) Namespace() string {
+	if c.name != "" {
+		return c.appName + ":" + c.name
+	}
+	return c.appName
+}
+
+// GetRedisClient returns the current client. In failover mode it may be
+// replaced when the sentinel addresses change, so do not keep it around.
 func (c *Cache) GetRedisClient() redis.UniversalClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.redisClient
 }
 
 func (c *Cache) GetDCache() *dcache.DCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.dCache
 }
+
+func (c *Cache) getBus() *invalidationBus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.bus
+}
+
+func (c *Cache) timeNow() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
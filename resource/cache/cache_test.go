@@ -0,0 +1,279 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ggsrc/gglib/resource/cache"
+	"github.com/ggsrc/gglib/resource/cache/cachetest"
+)
+
+func TestCache_NamedInstancesDoNotCollide(t *testing.T) {
+	sessions := cachetest.New(t, cache.WithName("session"))
+	features := cachetest.NewWithServer(t, sessions.Redis, cache.WithName("feature"))
+	ctx := context.Background()
+
+	if sessions.Name() != "cache_session" || features.Name() != "cache_feature" {
+		t.Fatalf("Expected distinct resource names, got %s and %s", sessions.Name(), features.Name())
+	}
+	if sessions.Namespace() == features.Namespace() {
+		t.Fatalf("Expected distinct namespaces, got %s", sessions.Namespace())
+	}
+
+	sessionValues := cache.NewTyped[string](sessions.Cache, "v", nil)
+	featureValues := cache.NewTyped[string](features.Cache, "v", nil)
+	if err := sessionValues.Set(ctx, "k", "session"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := featureValues.Set(ctx, "k", "feature"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for _, key := range []string{"cachetest:session:{v:k}", "cachetest:feature:{v:k}"} {
+		if !sessions.Redis.Exists(key) {
+			t.Errorf("Expected key %s, got %v", key, sessions.Keys())
+		}
+	}
+	before := map[string]float64{}
+	for _, name := range []string{"session", "feature"} {
+		hits, err := typedRequestCount(name, "hit")
+		if err != nil {
+			t.Fatalf("Gather failed: %v", err)
+		}
+		before[name] = hits
+	}
+	if v, err := sessionValues.Get(ctx, "k"); err != nil || v != "session" {
+		t.Errorf("Expected the session value, got %q, %v", v, err)
+	}
+	if v, err := featureValues.Get(ctx, "k"); err != nil || v != "feature" {
+		t.Errorf("Expected the feature value, got %q, %v", v, err)
+	}
+
+	// invalidating a key of one cache leaves the same key of the other one
+	if err := sessionValues.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := sessionValues.Get(ctx, "k"); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("Expected the session value to be deleted, got %v", err)
+	}
+	if v, err := featureValues.Get(ctx, "k"); err != nil || v != "feature" {
+		t.Errorf("Expected the feature value to be kept, got %q, %v", v, err)
+	}
+
+	// typed metrics are labeled by cache
+	for name, want := range map[string]float64{"session": 1, "feature": 2} {
+		hits, err := typedRequestCount(name, "hit")
+		if err != nil {
+			t.Fatalf("Gather failed: %v", err)
+		}
+		if hits-before[name] != want {
+			t.Errorf("Expected %v hits of cache %s, got %v", want, name, hits-before[name])
+		}
+	}
+}
+
+func typedRequestCount(cacheName, result string) (float64, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, family := range families {
+		if family.GetName() != "gglib_cache_typed_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["cache"] == cacheName && labels["result"] == result {
+				total += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return total, nil
+}
+
+func TestCache_OKFailsWhenANodeIsDown(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c := cache.NewCacheWithOptions(
+		cache.WithAppName("cachetest"),
+		cache.WithRedisConfig(&cache.RedisConfig{IsClusterMode: true}),
+		cache.WithDCacheConfig(&cache.DCacheConfig{
+			ReadInterval:     500 * time.Millisecond,
+			InMemCacheSize:   1024 * 1024,
+			InvalidationMode: cache.InvalidationModeDisabled,
+		}),
+		cache.WithRedisClient(redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:      []string{srv.Addr()},
+			MaxRetries: -1,
+		})),
+	)
+	ctx := context.Background()
+	if err := c.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	defer func() { _ = c.Stop(ctx) }()
+	if err := c.OK(ctx); err != nil {
+		t.Fatalf("Expected OK to succeed, got %v", err)
+	}
+
+	addr := srv.Addr()
+	srv.Close()
+	err := c.OK(ctx)
+	if err == nil || !strings.Contains(err.Error(), "redis node "+addr) {
+		t.Errorf("Expected OK to report the failed node, got %v", err)
+	}
+	if c.Available() {
+		t.Error("Expected the cache to be unavailable")
+	}
+}
+
+func TestCache_OKInDegradedMode(t *testing.T) {
+	fake := cachetest.New(t, cache.WithRedisConfig(&cache.RedisConfig{AllowUnavailable: true}))
+	ctx := context.Background()
+
+	fake.Redis.Close()
+	if err := fake.OK(ctx); err != nil {
+		t.Errorf("Expected OK to tolerate an unavailable redis, got %v", err)
+	}
+	if fake.Available() {
+		t.Error("Expected the cache to be unavailable")
+	}
+
+	if err := fake.Redis.Restart(); err != nil {
+		t.Fatalf("failed to restart redis: %v", err)
+	}
+	if err := fake.OK(ctx); err != nil || !fake.Available() {
+		t.Errorf("Expected the cache to be available again, got %v", err)
+	}
+}
+
+func TestCache_StatsCollector(t *testing.T) {
+	fake := cachetest.New(t, cache.WithName("stats"))
+	ctx := context.Background()
+
+	users := cache.NewTyped[user](fake.Cache, "user", nil)
+	if err := users.Set(ctx, "1", user{ID: "1"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := users.Get(ctx, "1"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["cache"] != "stats" {
+				continue
+			}
+			name := family.GetName()
+			if state, ok := labels["state"]; ok {
+				name += "{" + state + "}"
+			}
+			values[name] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+		}
+	}
+	if values["gglib_cache_mem_entries"] != 1 {
+		t.Errorf("Expected 1 in-memory entry, got %v", values)
+	}
+	if values["gglib_cache_redis_pool_conns{total}"] < 1 {
+		t.Errorf("Expected open redis connections, got %v", values)
+	}
+	for _, name := range []string{
+		"gglib_cache_redis_pool_hits_total", "gglib_cache_redis_pool_misses_total",
+		"gglib_cache_redis_pool_timeouts_total", "gglib_cache_redis_pool_conns{idle}",
+		"gglib_cache_mem_hit_rate", "gglib_cache_mem_evacuations_total", "gglib_cache_mem_expirations_total",
+	} {
+		if _, ok := values[name]; !ok {
+			t.Errorf("Expected %s to be exported, got %v", name, values)
+		}
+	}
+
+}
+
+func TestCache_InitClosesClientOnFailure(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	c := cache.NewCacheWithOptions(
+		cache.WithAppName("cachetest"),
+		cache.WithRedisConfig(&cache.RedisConfig{}),
+		cache.WithDCacheConfig(&cache.DCacheConfig{
+			ReadInterval:     500 * time.Millisecond,
+			InMemCacheSize:   1024 * 1024,
+			InvalidationMode: "unknown",
+		}),
+		cache.WithRedisClient(rdb),
+	)
+	ctx := context.Background()
+	if err := c.Init(ctx); err == nil {
+		t.Fatal("Expected Init to fail on an unknown invalidation mode")
+	}
+	if err := rdb.Ping(ctx).Err(); !errors.Is(err, redis.ErrClosed) {
+		t.Errorf("Expected the client to be closed, got %v", err)
+	}
+	if err := c.Stop(ctx); err != nil {
+		t.Errorf("Expected Stop after a failed Init to succeed, got %v", err)
+	}
+}
+
+func TestCache_DCacheStatsExportedByOneCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	newCache := func(name string, enableStats bool) *cache.Cache {
+		return cache.NewCacheWithOptions(
+			cache.WithAppName("cachetest"),
+			cache.WithName(name),
+			cache.WithRedisConfig(&cache.RedisConfig{}),
+			cache.WithDCacheConfig(&cache.DCacheConfig{
+				ReadInterval:     500 * time.Millisecond,
+				InMemCacheSize:   1024 * 1024,
+				InvalidationMode: cache.InvalidationModeDisabled,
+				EnableStats:      enableStats,
+			}),
+			cache.WithRedisClient(redis.NewClient(&redis.Options{Addr: srv.Addr()})),
+		)
+	}
+	ctx := context.Background()
+
+	first := newCache("first", true)
+	if err := first.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	err := newCache("second", true).Init(ctx)
+	if err == nil || !strings.Contains(err.Error(), "already exported by cache_first") {
+		t.Errorf("Expected a second cache exporting dcache metrics to fail, got %v", err)
+	}
+	quiet := newCache("quiet", false)
+	if err = quiet.Init(ctx); err != nil {
+		t.Errorf("Expected a cache without dcache metrics to init, got %v", err)
+	}
+	if err = quiet.Stop(ctx); err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+
+	if err = first.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	third := newCache("third", true)
+	if err = third.Init(ctx); err != nil {
+		t.Errorf("Expected dcache metrics to be released on Stop, got %v", err)
+	}
+	if err = third.Stop(ctx); err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Package cachetest provides a cache.Cache backed by an in-process
+// Redis-compatible server and a real freecache for unit tests, so code
+// depending on cache.Cache runs in go test without external services.
+package cachetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stumble/dcache"
+
+	"github.com/ggsrc/gglib/resource/cache"
+)
+
+const defaultAppName = "cachetest"
+
+// Fake is an initialized cache.Cache with a controllable clock. It is
+// stopped when the test finishes.
+//
+// The clock is shared with dcache through dcache.SetNowFunc, which is
+// process-wide, so tests using Fake must not run in parallel.
+type Fake struct {
+	*cache.Cache
+	// Redis is the in-process server, for direct inspection and manipulation.
+	Redis *miniredis.Miniredis
+
+	mu  sync.Mutex
+	now time.Time
+}
+
+// New starts an in-process Redis and returns an initialized cache wired to
+// it. opts are applied on top of the test defaults and may override the app
+// name and the dcache config.
+func New(tb testing.TB, opts ...cache.Option) *Fake {
+	tb.Helper()
+	return NewWithServer(tb, miniredis.RunT(tb), opts...)
+}
+
+// NewWithServer is like New on an existing in-process Redis, so that several
+// caches, e.g. replicas of an app or named caches, share it.
+func NewWithServer(tb testing.TB, srv *miniredis.Miniredis, opts ...cache.Option) *Fake {
+	tb.Helper()
+	f := &Fake{
+		Redis: srv,
+		now:   time.Now(),
+	}
+	f.Redis.SetTime(f.now)
+
+	defaults := []cache.Option{
+		cache.WithAppName(defaultAppName),
+		cache.WithRedisConfig(&cache.RedisConfig{}),
+		cache.WithDCacheConfig(&cache.DCacheConfig{
+			ReadInterval:     500 * time.Millisecond,
+			InMemCacheSize:   1024 * 1024,
+			InvalidationMode: cache.InvalidationModePubSub,
+		}),
+	}
+	opts = append(defaults, opts...)
+	opts = append(opts,
+		cache.WithRedisClient(redis.NewClient(&redis.Options{Addr: f.Redis.Addr()})),
+		cache.WithNowFunc(f.Now),
+	)
+	f.Cache = cache.NewCacheWithOptions(opts...)
+
+	dcache.SetNowFunc(f.Now)
+	ctx := context.Background()
+	if err := f.Init(ctx); err != nil {
+		tb.Fatalf("failed to init fake cache: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := f.Stop(ctx); err != nil {
+			tb.Errorf("failed to stop fake cache: %v", err)
+		}
+		dcache.SetNowFunc(time.Now)
+	})
+	return f
+}
+
+// Now returns the current time of the fake clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock of the cache, of dcache and of Redis forward by d,
+// expiring keys of both tiers accordingly.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	now := f.now
+	f.mu.Unlock()
+	f.Redis.SetTime(now)
+	f.Redis.FastForward(d)
+}
+
+// Keys returns every key stored in Redis, sorted.
+func (f *Fake) Keys() []string {
+	return f.Redis.Keys()
+}
+
+// Has reports whether the dcache key (e.g. Typed.Key(key)) exists in Redis.
+func (f *Fake) Has(key string) bool {
+	return f.Redis.Exists(f.StoreKey(key))
+}
+
+// TTL returns the remaining Redis TTL of the dcache key.
+func (f *Fake) TTL(key string) time.Duration {
+	return f.Redis.TTL(f.StoreKey(key))
+}
+
+// StoreKey returns the Redis key under which dcache stores key.
+func (f *Fake) StoreKey(key string) string {
+	return f.Namespace() + ":{" + key + "}"
+}
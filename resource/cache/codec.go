@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"reflect"
+
+	"github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec serializes values stored through Typed.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSONCodec encodes values as JSON using sonic. It is the default codec.
+	JSONCodec Codec = jsonCodec{}
+	// ProtoCodec encodes proto.Message values (e.g. Typed[*pb.User]) in protobuf wire format.
+	ProtoCodec Codec = protoCodec{}
+	// MsgpackCodec encodes values with msgpack.
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return sonic.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return sonic.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "proto" }
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("proto codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal accepts either a proto.Message or a pointer to a (possibly nil)
+// proto.Message pointer, which is what Typed passes for T = *pb.Message.
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Ptr {
+		elem := reflect.New(rv.Elem().Type().Elem())
+		if m, ok := elem.Interface().(proto.Message); ok {
+			if err := proto.Unmarshal(data, m); err != nil {
+				return err
+			}
+			rv.Elem().Set(elem)
+			return nil
+		}
+	}
+	return errors.Errorf("proto codec: %T is not a proto.Message", v)
+}
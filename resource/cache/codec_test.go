@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestValue struct {
+	Name  string `json:"name"  msgpack:"name"`
+	Count int    `json:"count" msgpack:"count"`
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			in := codecTestValue{Name: "gglib", Count: 3}
+			b, err := codec.Marshal(in)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			var out codecTestValue
+			if err := codec.Unmarshal(b, &out); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if out != in {
+				t.Errorf("Expected %+v, got %+v", in, out)
+			}
+		})
+	}
+}
+
+func TestProtoCodec_UnmarshalIntoNilPointer(t *testing.T) {
+	b, err := ProtoCodec.Marshal(wrapperspb.String("gglib"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var out *wrapperspb.StringValue
+	if err := ProtoCodec.Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if out.GetValue() != "gglib" {
+		t.Errorf("Expected gglib, got %q", out.GetValue())
+	}
+
+	if _, err := ProtoCodec.Marshal("not a message"); err == nil {
+		t.Error("Expected error when marshaling a non proto value")
+	}
+}
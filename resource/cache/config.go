@@ -5,19 +5,53 @@ import "time"
 type RedisConfig struct {
 	Host                string        `default:"127.0.0.1"`
 	Port                int           `default:"6379"`
+	Username            string        `default:""`
 	Password            string        `default:""          mask:"fixed"`
+	DB                  int           `default:"0"` // ignored in cluster mode
 	IsFailover          bool          `default:"false"`
 	IsElastiCache       bool          `default:"false"`
 	IsClusterMode       bool          `default:"false"`
 	ClusterAddrs        []string      `default:""`
 	ClusterMaxRedirects int           `default:"3"`
+	DialTimeout         time.Duration `default:"5s"`
 	ReadTimeout         time.Duration `default:"3s"`
+	WriteTimeout        time.Duration `default:"3s"`
 	PoolSize            int           `default:"50"`
+	MinIdleConns        int           `default:"0"`
+	// ConnectTimeout bounds connection retries in Init when its ctx has no deadline.
+	ConnectTimeout time.Duration `default:"10s"`
+	// AllowUnavailable lets Init succeed while Redis is unreachable. The cache
+	// then runs in degraded mode: Typed reads fall back to their loaders and
+	// OK does not fail until Redis comes back.
+	AllowUnavailable bool `default:"false"`
+
+	// Sentinel options, used in failover mode.
+	SentinelMasterName string `default:"master"`
+	SentinelUsername   string `default:""`
+	SentinelPassword   string `default:""       mask:"fixed"`
+	// SentinelResolveInterval is how often the sentinel host is re-resolved.
+	// The client is rebuilt when its addresses change, 0 disables it.
+	SentinelResolveInterval time.Duration `default:"30s"`
+
+	// TLS options. TLS is enabled by TLSEnabled or IsElastiCache. Certificates
+	// are verified against TLSServerName if set, otherwise against the dialed
+	// host, using TLSCAFile or the system roots. ElastiCache certificates do
+	// not cover CNAME records: set TLSServerName to the endpoint of the
+	// certificate. Verification is only skipped with TLSInsecureSkipVerify.
+	TLSEnabled            bool   `default:"false"`
+	TLSCAFile             string `default:""`
+	TLSCertFile           string `default:""`
+	TLSKeyFile            string `default:""`
+	TLSServerName         string `default:""`
+	TLSInsecureSkipVerify bool   `default:"false"`
 }
 
 type DCacheConfig struct {
 	ReadInterval   time.Duration `default:"500ms"`
-	EnableStats    bool          `default:"true"`
+	EnableStats    bool          `default:"true"` // the global dcache metrics, exported by one cache of the process
 	EnableTrace    bool          `default:"true"`
 	InMemCacheSize int           `default:"52428800"` // base unit in byte: 50 * 1024 * 1024 = 52428800 -> 50MB
+	// InvalidationMode is how Cache.Invalidate reaches other replicas: pubsub, stream or disabled.
+	InvalidationMode         string `default:"pubsub"`
+	InvalidationStreamMaxLen int64  `default:"10000"`
 }
@@ -1,19 +1,73 @@
 package cache
 
 import (
+	"sync"
+	"time"
+
 	"github.com/coocood/freecache"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"github.com/stumble/dcache"
 )
 
-func (cache *Cache) newDCacheWithConfig() (*dcache.DCache, error) {
+// newDCacheWithConfig creates a dcache on client. The in-memory tier is
+// created once and kept when dcache is rebuilt on a new client.
+func (cache *Cache) newDCacheWithConfig(client redis.UniversalClient) (*dcache.DCache, error) {
 	c := cache.dCacheConfig
 	log.Warn().Msgf("DCache Config: %+v", c)
+	if cache.memCache == nil {
+		if cache.now != nil {
+			cache.memCache = freecache.NewCacheCustomTimer(c.InMemCacheSize, nowTimer(cache.now))
+		} else {
+			cache.memCache = freecache.NewCache(c.InMemCacheSize)
+		}
+	}
 	return dcache.NewDCache(
-		cache.appName,
-		cache.redisClient,
-		freecache.NewCache(c.InMemCacheSize),
+		cache.Namespace(),
+		client,
+		cache.memCache,
 		c.ReadInterval,
 		c.EnableStats,
 		c.EnableTrace)
 }
+
+// dcacheStatsOwner is the cache exporting the dcache metrics. dcache
+// registers them globally, labeled by namespace: those of a second dcache
+// cannot be registered, and closing it would unregister the owner's.
+var dcacheStatsOwner struct {
+	mu    sync.Mutex
+	cache *Cache
+}
+
+// claimDCacheStats makes c the cache exporting the dcache metrics if its
+// EnableStats is set, failing if another cache already does.
+func (c *Cache) claimDCacheStats() error {
+	if !c.dCacheConfig.EnableStats {
+		return nil
+	}
+	dcacheStatsOwner.mu.Lock()
+	defer dcacheStatsOwner.mu.Unlock()
+	if owner := dcacheStatsOwner.cache; owner != nil && owner != c {
+		return errors.Errorf("cache: dcache metrics are global and already exported by %s, disable EnableStats of %s",
+			owner.Name(), c.Name())
+	}
+	dcacheStatsOwner.cache = c
+	return nil
+}
+
+func (c *Cache) releaseDCacheStats() {
+	dcacheStatsOwner.mu.Lock()
+	defer dcacheStatsOwner.mu.Unlock()
+	if dcacheStatsOwner.cache == c {
+		dcacheStatsOwner.cache = nil
+	}
+}
+
+// nowTimer adapts a now function to freecache.Timer.
+type nowTimer func() time.Time
+
+func (t nowTimer) Now() uint32 {
+	// nolint: gosec
+	return uint32(t().Unix())
+}
@@ -0,0 +1,316 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	InvalidationModePubSub   = "pubsub"
+	InvalidationModeStream   = "stream"
+	InvalidationModeDisabled = "disabled"
+
+	invalidationStreamBlock = 5 * time.Second
+	invalidationRetryDelay  = time.Second
+	tagKeyPrefix            = "tag:"
+)
+
+// invalidationMessage is published to every replica when keys are invalidated.
+type invalidationMessage struct {
+	Origin string   `json:"o"`
+	Keys   []string `json:"k"`
+	SentAt int64    `json:"t"` // UNIX timestamp in milliseconds
+}
+
+// invalidationBus evicts keys from the in-memory tier of every replica
+// through Redis pub/sub or a Redis stream.
+type invalidationBus struct {
+	cache   *Cache
+	origin  string
+	mode    string
+	channel string
+	maxLen  int64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newInvalidationBus(c *Cache) *invalidationBus {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return &invalidationBus{
+		cache:   c,
+		origin:  hex.EncodeToString(b),
+		mode:    c.dCacheConfig.InvalidationMode,
+		channel: c.Namespace() + ":cache-invalidation",
+		maxLen:  c.dCacheConfig.InvalidationStreamMaxLen,
+	}
+}
+
+func (b *invalidationBus) start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	switch b.mode {
+	case InvalidationModePubSub:
+		pubsub := b.cache.GetRedisClient().Subscribe(ctx, b.channel)
+		// wait for the subscription to be confirmed so that no message is missed after Init,
+		// unless Redis is down in degraded mode: pubsub resubscribes once it is back.
+		if b.cache.Available() {
+			if _, err := pubsub.Receive(ctx); err != nil {
+				cancel()
+				_ = pubsub.Close()
+				return errors.Wrapf(err, "failed to subscribe to %s", b.channel)
+			}
+		}
+		b.wg.Add(1)
+		go b.listenPubSub(ctx, pubsub)
+	case InvalidationModeStream:
+		// read after the last entry so that no entry added after Init is missed,
+		// unless Redis is down in degraded mode: it is resolved once Redis is back.
+		var lastID string
+		if b.cache.Available() {
+			var err error
+			if lastID, err = b.lastStreamID(ctx); err != nil {
+				cancel()
+				return errors.Wrapf(err, "failed to read the last entry of %s", b.channel)
+			}
+		}
+		b.wg.Add(1)
+		go b.listenStream(ctx, lastID)
+	default:
+		cancel()
+		return errors.Errorf("unknown invalidation mode %q", b.mode)
+	}
+	return nil
+}
+
+func (b *invalidationBus) stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+}
+
+// restart resubscribes on the current redis client of the cache.
+func (b *invalidationBus) restart() error {
+	b.stop()
+	return b.start()
+}
+
+func (b *invalidationBus) publish(ctx context.Context, keys []string) error {
+	payload, err := sonic.MarshalString(invalidationMessage{
+		Origin: b.origin,
+		Keys:   keys,
+		SentAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	if b.mode == InvalidationModeStream {
+		return b.cache.GetRedisClient().XAdd(ctx, &redis.XAddArgs{
+			Stream: b.channel,
+			MaxLen: b.maxLen,
+			Approx: true,
+			Values: map[string]any{"m": payload},
+		}).Err()
+	}
+	return b.cache.GetRedisClient().Publish(ctx, b.channel, payload).Err()
+}
+
+func (b *invalidationBus) listenPubSub(ctx context.Context, pubsub *redis.PubSub) {
+	defer b.wg.Done()
+	defer func() {
+		_ = pubsub.Close()
+	}()
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			b.handle(msg.Payload)
+		}
+	}
+}
+
+// listenStream reads the entries of the stream after lastID, resolving it
+// first if it is empty.
+func (b *invalidationBus) listenStream(ctx context.Context, lastID string) {
+	defer b.wg.Done()
+	for {
+		if lastID == "" {
+			id, err := b.lastStreamID(ctx)
+			if err != nil {
+				if !b.retryStream(ctx, err) {
+					return
+				}
+				continue
+			}
+			lastID = id
+		}
+		streams, err := b.cache.GetRedisClient().XRead(ctx, &redis.XReadArgs{
+			Streams: []string{b.channel, lastID},
+			Block:   invalidationStreamBlock,
+		}).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			if !b.retryStream(ctx, err) {
+				return
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				lastID = msg.ID
+				if payload, ok := msg.Values["m"].(string); ok {
+					b.handle(payload)
+				}
+			}
+		}
+	}
+}
+
+// lastStreamID returns the ID of the last entry of the stream, 0-0 if it
+// is empty. Unlike $, reading after it returns the entries added between
+// two reads.
+func (b *invalidationBus) lastStreamID(ctx context.Context) (string, error) {
+	msgs, err := b.cache.GetRedisClient().XRevRangeN(ctx, b.channel, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+// retryStream logs err and waits before reading the stream again,
+// returning false if ctx is done first.
+func (b *invalidationBus) retryStream(ctx context.Context, err error) bool {
+	log.Err(err).Msgf("failed to read invalidation stream %s", b.channel)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(invalidationRetryDelay):
+		return true
+	}
+}
+
+func (b *invalidationBus) handle(payload string) {
+	var msg invalidationMessage
+	if err := sonic.UnmarshalString(payload, &msg); err != nil {
+		log.Err(err).Msgf("received invalid invalidation payload %s", payload)
+		return
+	}
+	if msg.Origin == b.origin {
+		// local entries have been evicted when publishing
+		return
+	}
+	b.cache.evictLocal(msg.Keys...)
+	invalidationLag.WithLabelValues(b.cache.appName, b.cache.name).
+		Observe(time.Since(time.UnixMilli(msg.SentAt)).Seconds())
+	invalidatedKeys.WithLabelValues(b.cache.appName, b.cache.name).Add(float64(len(msg.Keys)))
+}
+
+// Invalidate deletes keys from Redis and from the in-memory tier of every
+// replica. Keys are dcache keys, e.g. Typed.Key(key).
+func (c *Cache) Invalidate(ctx context.Context, keys ...string) error {
+	if !c.initialized {
+		return errNotInitialized
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if err := c.GetDCache().Invalidate(ctx, key); err != nil {
+			return errors.Wrapf(err, "failed to invalidate %s", key)
+		}
+	}
+	return c.evictReplicas(ctx, keys...)
+}
+
+// evictReplicas evicts keys from the in-memory tier of the other replicas,
+// after they were written or deleted in Redis.
+func (c *Cache) evictReplicas(ctx context.Context, keys ...string) error {
+	bus := c.getBus()
+	if bus == nil || len(keys) == 0 {
+		return nil
+	}
+	return errors.Wrap(bus.publish(ctx, keys), "failed to publish invalidation")
+}
+
+// Tag associates keys with tag so that they can be invalidated together by
+// InvalidateTags, e.g. every key holding data of one user. Tags expire after
+// ttl, which should cover the TTL of the tagged keys.
+func (c *Cache) Tag(ctx context.Context, tag string, ttl time.Duration, keys ...string) error {
+	if !c.initialized {
+		return errNotInitialized
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	members := make([]any, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	tagKey := c.tagKey(tag)
+	_, err := c.GetRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, tagKey, members...)
+		pipe.Expire(ctx, tagKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// InvalidateTags invalidates every key associated with tags.
+func (c *Cache) InvalidateTags(ctx context.Context, tags ...string) error {
+	if !c.initialized {
+		return errNotInitialized
+	}
+	for _, tag := range tags {
+		tagKey := c.tagKey(tag)
+		keys, err := c.GetRedisClient().SMembers(ctx, tagKey).Result()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read tag %s", tag)
+		}
+		if err = c.Invalidate(ctx, keys...); err != nil {
+			return err
+		}
+		if err = c.GetRedisClient().Del(ctx, tagKey).Err(); err != nil {
+			return errors.Wrapf(err, "failed to delete tag %s", tag)
+		}
+	}
+	return nil
+}
+
+func (c *Cache) tagKey(tag string) string {
+	return c.Namespace() + ":" + tagKeyPrefix + tag
+}
+
+// evictLocal deletes keys from the in-memory tier only.
+func (c *Cache) evictLocal(keys ...string) {
+	if c.memCache == nil {
+		return
+	}
+	for _, key := range keys {
+		c.memCache.Del([]byte(dcacheStoreKey(c.Namespace(), key)))
+	}
+}
+
+// dcacheStoreKey mirrors how dcache names keys in Redis and in memory.
+func dcacheStoreKey(namespace, key string) string {
+	return namespace + ":{" + key + "}"
+}
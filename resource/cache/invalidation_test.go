@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCache_StreamInvalidationReadsAfterLastEntry(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	c := NewCacheWithOptions(
+		WithAppName("streamtest"),
+		WithRedisConfig(&RedisConfig{}),
+		WithDCacheConfig(&DCacheConfig{
+			ReadInterval:     500 * time.Millisecond,
+			InMemCacheSize:   1024 * 1024,
+			InvalidationMode: InvalidationModeStream,
+		}),
+		WithRedisClient(rdb),
+	)
+	channel := c.Namespace() + ":cache-invalidation"
+	ctx := context.Background()
+	invalidate := func(key string) {
+		t.Helper()
+		payload, err := sonic.MarshalString(invalidationMessage{Origin: "other", Keys: []string{key}, SentAt: time.Now().UnixMilli()})
+		if err != nil {
+			t.Fatalf("failed to encode message: %v", err)
+		}
+		if err = rdb.XAdd(ctx, &redis.XAddArgs{Stream: channel, Values: map[string]any{"m": payload}}).Err(); err != nil {
+			t.Fatalf("failed to add to the stream: %v", err)
+		}
+	}
+
+	invalidate("before")
+	if err := c.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := c.Stop(ctx); err != nil {
+			t.Errorf("Stop failed: %v", err)
+		}
+	})
+	cached := func(key string) bool {
+		_, err := c.memCache.Get([]byte(dcacheStoreKey(c.Namespace(), key)))
+		return err == nil
+	}
+	for _, key := range []string{"before", "first", "second"} {
+		if err := c.memCache.Set([]byte(dcacheStoreKey(c.Namespace(), key)), []byte("v"), 0); err != nil {
+			t.Fatalf("failed to cache %s: %v", key, err)
+		}
+	}
+
+	// entries added back to back, possibly between two reads, are all read.
+	invalidate("first")
+	invalidate("second")
+	deadline := time.Now().Add(2 * time.Second)
+	for cached("first") || cached("second") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected entries added after Init to be read, first cached: %v, second cached: %v",
+				cached("first"), cached("second"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !cached("before") {
+		t.Error("Expected entries added before Init not to be read")
+	}
+}
+
+func TestInvalidationBus_LastStreamID(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c := &Cache{appName: "streamtest", redisClient: redis.NewClient(&redis.Options{Addr: srv.Addr()})}
+	b := &invalidationBus{cache: c, channel: "stream"}
+	ctx := context.Background()
+
+	if id, err := b.lastStreamID(ctx); err != nil || id != "0-0" {
+		t.Errorf("Expected 0-0 for a missing stream, got %q, %v", id, err)
+	}
+	for _, id := range []string{"1-0", "2-0"} {
+		if _, err := srv.XAdd("stream", id, []string{"m", "{}"}); err != nil {
+			t.Fatalf("failed to add to the stream: %v", err)
+		}
+	}
+	if id, err := b.lastStreamID(ctx); err != nil || id != "2-0" {
+		t.Errorf("Expected the last entry 2-0, got %q, %v", id, err)
+	}
+}
@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const name = "github.com/ggsrc/gglib/resource/cache"
+
+var tracer = otel.Tracer(name)
+
+var (
+	// ErrLockNotAcquired is returned by TryLock when the lock is held by someone else.
+	ErrLockNotAcquired = errors.New("cache: lock not acquired")
+	// ErrLockNotHeld is returned by Release when the lock expired or was taken over.
+	ErrLockNotHeld = errors.New("cache: lock not held")
+)
+
+const (
+	defaultLockTTL        = 30 * time.Second
+	defaultLockMinBackoff = 10 * time.Millisecond
+	defaultLockMaxBackoff = 500 * time.Millisecond
+	// minLockTTL keeps the TTL above the millisecond precision of Redis
+	// expirations, with room for auto-extension every TTL/3.
+	minLockTTL = 10 * time.Millisecond
+	// redlockDriftFactor accounts for clock drift between Redlock nodes.
+	redlockDriftFactor = 0.01
+)
+
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+var extendLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+type lockOptions struct {
+	ttl        time.Duration
+	autoExtend bool
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	redlock    []redis.UniversalClient
+}
+
+type LockOption func(*lockOptions)
+
+// WithLockTTL sets how long the lock is held without extension, 30s by
+// default. Lock and TryLock fail if it is below 10ms.
+func WithLockTTL(ttl time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithLockAutoExtend controls whether the lock is extended every TTL/3 while
+// held, enabled by default. See Lock.Lost for extension failures.
+func WithLockAutoExtend(enabled bool) LockOption {
+	return func(o *lockOptions) {
+		o.autoExtend = enabled
+	}
+}
+
+// WithLockBackoff sets the bounds of the exponential backoff between
+// acquisition attempts of Lock. Lock and TryLock fail if minBackoff is not
+// positive or above maxBackoff.
+func WithLockBackoff(minBackoff, maxBackoff time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.minBackoff = minBackoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithRedlock acquires the lock on a majority of independent Redis nodes
+// following the Redlock algorithm, instead of on the cache client.
+func WithRedlock(clients ...redis.UniversalClient) LockOption {
+	return func(o *lockOptions) {
+		o.redlock = clients
+	}
+}
+
+// Lock is a token-owned distributed lock. It must be released by Release.
+type Lock struct {
+	key     string
+	token   string
+	clients []redis.UniversalClient
+	opts    lockOptions
+
+	holdSpan trace.Span
+	cancel   context.CancelFunc
+	done     chan struct{}
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+// Lock acquires the lock name, waiting with backoff until it is free or ctx is done.
+func (c *Cache) Lock(ctx context.Context, name string, opts ...LockOption) (*Lock, error) {
+	return c.lock(ctx, name, true, opts)
+}
+
+// TryLock acquires the lock name once, returning ErrLockNotAcquired if it is held.
+func (c *Cache) TryLock(ctx context.Context, name string, opts ...LockOption) (*Lock, error) {
+	return c.lock(ctx, name, false, opts)
+}
+
+func (c *Cache) lock(ctx context.Context, name string, wait bool, opts []LockOption) (l *Lock, err error) {
+	o := lockOptions{
+		ttl:        defaultLockTTL,
+		autoExtend: true,
+		minBackoff: defaultLockMinBackoff,
+		maxBackoff: defaultLockMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl < minLockTTL {
+		return nil, errors.Errorf("cache: lock ttl %s is below %s", o.ttl, minLockTTL)
+	}
+	if o.minBackoff <= 0 || o.minBackoff > o.maxBackoff {
+		return nil, errors.Errorf("cache: invalid lock backoff [%s, %s]", o.minBackoff, o.maxBackoff)
+	}
+	clients := o.redlock
+	if len(clients) == 0 {
+		client := c.GetRedisClient()
+		if client == nil {
+			return nil, errNotInitialized
+		}
+		clients = []redis.UniversalClient{client}
+	}
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	l = &Lock{
+		key:     c.Namespace() + ":lock:" + name,
+		token:   token,
+		clients: clients,
+		opts:    o,
+		done:    make(chan struct{}),
+		lost:    make(chan struct{}),
+	}
+
+	ctx, span := tracer.Start(ctx, "cache.lock.wait", trace.WithAttributes(attribute.String("lock.key", l.key)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	backoff := o.minBackoff
+	for attempt := 1; ; attempt++ {
+		acquired, err := l.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			span.SetAttributes(attribute.Int("lock.attempts", attempt))
+			l.hold(ctx)
+			return l, nil
+		}
+		if !wait {
+			return nil, ErrLockNotAcquired
+		}
+		// nolint: gosec
+		sleep := backoff/2 + time.Duration(rand.Int64N(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "failed to acquire lock %s", name)
+		case <-time.After(sleep):
+		}
+		backoff = min(backoff*2, o.maxBackoff)
+	}
+}
+
+// Token returns the random token identifying the owner of the lock.
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Lost is closed when auto-extension fails and the lock may have expired.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops auto-extension and deletes the lock if it is still owned.
+func (l *Lock) Release(ctx context.Context) error {
+	l.cancel()
+	<-l.done
+	defer l.holdSpan.End()
+
+	released := 0
+	var lastErr error
+	for _, client := range l.clients {
+		n, err := releaseLockScript.Run(ctx, client, []string{l.key}, l.token).Int64()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		released += int(n)
+	}
+	if released == 0 {
+		if lastErr != nil {
+			return errors.Wrap(lastErr, "failed to release lock")
+		}
+		l.holdSpan.SetStatus(codes.Error, ErrLockNotHeld.Error())
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// acquire sets the lock on a quorum of clients within its validity time,
+// undoing partial acquisitions otherwise.
+func (l *Lock) acquire(ctx context.Context) (bool, error) {
+	startedAt := time.Now()
+	acquired := 0
+	var lastErr error
+	for _, client := range l.clients {
+		ok, err := client.SetNX(ctx, l.key, l.token, l.opts.ttl).Result()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			acquired++
+		}
+	}
+	if len(l.clients) == 1 && lastErr != nil {
+		return false, errors.Wrap(lastErr, "failed to acquire lock")
+	}
+
+	drift := time.Duration(float64(l.opts.ttl)*redlockDriftFactor) + 2*time.Millisecond
+	validity := l.opts.ttl - time.Since(startedAt) - drift
+	if acquired >= l.quorum() && (len(l.clients) == 1 || validity > 0) {
+		return true, nil
+	}
+	if acquired > 0 {
+		for _, client := range l.clients {
+			releaseLockScript.Run(context.WithoutCancel(ctx), client, []string{l.key}, l.token)
+		}
+	}
+	return false, nil
+}
+
+func (l *Lock) quorum() int {
+	return len(l.clients)/2 + 1
+}
+
+// hold starts the hold span and the auto-extension of the lock.
+func (l *Lock) hold(ctx context.Context) {
+	ctx, l.holdSpan = tracer.Start(context.WithoutCancel(ctx), "cache.lock.hold",
+		trace.WithAttributes(attribute.String("lock.key", l.key)))
+	ctx, l.cancel = context.WithCancel(ctx)
+	if !l.opts.autoExtend {
+		close(l.done)
+		return
+	}
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(l.opts.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := l.extend(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Ctx(ctx).Err(err).Msgf("failed to extend lock %s", l.key)
+				l.holdSpan.AddEvent("lock lost")
+				l.lostOnce.Do(func() { close(l.lost) })
+				return
+			}
+		}
+	}()
+}
+
+func (l *Lock) extend(ctx context.Context) error {
+	extended := 0
+	for _, client := range l.clients {
+		n, err := extendLockScript.Run(ctx, client, []string{l.key}, l.token, l.opts.ttl.Milliseconds()).Int64()
+		if err == nil && n == 1 {
+			extended++
+		}
+	}
+	if extended < l.quorum() {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate lock token")
+	}
+	return hex.EncodeToString(b), nil
+}
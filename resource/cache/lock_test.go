@@ -0,0 +1,155 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ggsrc/gglib/resource/cache"
+	"github.com/ggsrc/gglib/resource/cache/cachetest"
+)
+
+func TestCache_LockIsExclusive(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	lock, err := fake.Lock(ctx, "job", cache.WithLockTTL(time.Minute))
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if _, err = fake.TryLock(ctx, "job"); !errors.Is(err, cache.ErrLockNotAcquired) {
+		t.Fatalf("Expected ErrLockNotAcquired, got %v", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err = fake.Lock(waitCtx, "job"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected waiting to time out, got %v", err)
+	}
+
+	if err = lock.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err = lock.Release(ctx); !errors.Is(err, cache.ErrLockNotHeld) {
+		t.Fatalf("Expected ErrLockNotHeld on second release, got %v", err)
+	}
+
+	lock, err = fake.TryLock(ctx, "job")
+	if err != nil {
+		t.Fatalf("Expected lock to be free after release, got %v", err)
+	}
+	_ = lock.Release(ctx)
+}
+
+func TestCache_Redlock(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	nodes := make([]*miniredis.Miniredis, 3)
+	clients := make([]redis.UniversalClient, 3)
+	for i := range nodes {
+		nodes[i] = miniredis.RunT(t)
+		clients[i] = redis.NewClient(&redis.Options{Addr: nodes[i].Addr()})
+	}
+
+	// a minority of the nodes being down does not prevent locking
+	nodes[2].Close()
+	lock, err := fake.TryLock(ctx, "job", cache.WithRedlock(clients...))
+	if err != nil {
+		t.Fatalf("Expected Redlock to succeed with a quorum, got %v", err)
+	}
+	if _, err = fake.TryLock(ctx, "job", cache.WithRedlock(clients...)); !errors.Is(err, cache.ErrLockNotAcquired) {
+		t.Fatalf("Expected ErrLockNotAcquired, got %v", err)
+	}
+	if err = lock.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// without a quorum the lock is not acquired and partial locks are undone
+	nodes[1].Close()
+	if _, err = fake.TryLock(ctx, "job", cache.WithRedlock(clients...)); !errors.Is(err, cache.ErrLockNotAcquired) {
+		t.Fatalf("Expected ErrLockNotAcquired without a quorum, got %v", err)
+	}
+	if keys := nodes[0].Keys(); len(keys) != 0 {
+		t.Errorf("Expected partial lock to be released, got %v", keys)
+	}
+}
+
+func TestCache_LockRejectsInvalidOptions(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	for _, opt := range []cache.LockOption{
+		cache.WithLockTTL(0),
+		cache.WithLockTTL(-time.Second),
+		cache.WithLockTTL(time.Nanosecond),
+		cache.WithLockBackoff(0, time.Second),
+		cache.WithLockBackoff(time.Second, time.Millisecond),
+	} {
+		if _, err := fake.TryLock(ctx, "job", opt); err == nil {
+			t.Errorf("Expected invalid options to be rejected")
+		}
+		if _, err := fake.Lock(ctx, "job", opt); err == nil {
+			t.Errorf("Expected invalid options to be rejected")
+		}
+	}
+	if keys := fake.Keys(); len(keys) != 0 {
+		t.Errorf("Expected no lock to be created, got %v", keys)
+	}
+}
+
+func TestCache_LockAutoExtends(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+	ttl := 300 * time.Millisecond
+
+	lock, err := fake.TryLock(ctx, "job", cache.WithLockTTL(ttl))
+	if err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	defer func() { _ = lock.Release(ctx) }()
+	key := fake.Namespace() + ":lock:job"
+
+	// Redis time only moves with Advance, the TTL is reset by extensions.
+	fake.Advance(ttl - 50*time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for fake.Redis.TTL(key) < ttl-10*time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the lock to be extended, TTL is %s", fake.Redis.TTL(key))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-lock.Lost():
+		t.Errorf("Expected the extended lock not to be lost")
+	default:
+	}
+}
+
+func TestCache_LockLost(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	lock, err := fake.TryLock(ctx, "job", cache.WithLockTTL(30*time.Millisecond))
+	if err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	// another owner took the lock over after it expired.
+	fake.Redis.Set(fake.Namespace()+":lock:job", "someone-else")
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Lost to be closed when the extension fails")
+	}
+	if err = lock.Release(ctx); !errors.Is(err, cache.ErrLockNotHeld) {
+		t.Errorf("Expected ErrLockNotHeld, got %v", err)
+	}
+	if v, _ := fake.Redis.Get(fake.Namespace() + ":lock:job"); v != "someone-else" {
+		t.Errorf("Expected the other owner's lock to be kept, got %q", v)
+	}
+}
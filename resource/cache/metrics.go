@@ -0,0 +1,142 @@
+package cache
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type typedResult string
+
+const (
+	typedResultHit      typedResult = "hit"
+	typedResultMiss     typedResult = "miss"
+	typedResultStale    typedResult = "stale"
+	typedResultNotFound typedResult = "not_found"
+	typedResultError    typedResult = "error"
+)
+
+var (
+	typedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gglib_cache_typed_requests_total",
+			Help: "typed cache lookups by result: {hit, miss, stale, not_found, error}.",
+		}, []string{"app", "cache", "namespace", "result"})
+	typedRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gglib_cache_typed_refreshes_total",
+			Help: "background refreshes of stale or early expiring keys by result.",
+		}, []string{"app", "cache", "namespace", "result"})
+	invalidationLag = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gglib_cache_invalidation_lag_seconds",
+			Help:    "delay between publishing an invalidation and evicting it on another replica.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"app", "cache"})
+	invalidatedKeys = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gglib_cache_invalidated_keys_total",
+			Help: "keys evicted from the in-memory tier on invalidations from other replicas.",
+		}, []string{"app", "cache"})
+	sentinelChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gglib_cache_sentinel_changes_total",
+			Help: "times the sentinel host resolved to a different address set.",
+		}, []string{"app", "cache"})
+	sentinelResolveErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gglib_cache_sentinel_resolve_errors_total",
+			Help: "failed attempts to re-resolve the sentinel host or switch to its new addresses.",
+		}, []string{"app", "cache"})
+	sentinelAddrsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gglib_cache_sentinel_addrs",
+			Help: "number of sentinel addresses in use.",
+		}, []string{"app", "cache"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		typedRequests, typedRefreshes, invalidationLag, invalidatedKeys,
+		sentinelChanges, sentinelResolveErrors, sentinelAddrsGauge)
+}
+
+// statsCollector exports the redis pool and freecache statistics of a Cache
+// when scraped. Its descriptors carry the app and cache labels as constant
+// labels so that named caches can register side by side.
+type statsCollector struct {
+	cache *Cache
+
+	redisPoolHitsDesc       *prometheus.Desc
+	redisPoolMissesDesc     *prometheus.Desc
+	redisPoolTimeoutsDesc   *prometheus.Desc
+	redisPoolConnsDesc      *prometheus.Desc
+	memCacheEntriesDesc     *prometheus.Desc
+	memCacheHitRateDesc     *prometheus.Desc
+	memCacheEvacuationsDesc *prometheus.Desc
+	memCacheExpirationsDesc *prometheus.Desc
+}
+
+func newStatsCollector(c *Cache) *statsCollector {
+	labels := prometheus.Labels{"app": c.appName, "cache": c.name}
+	return &statsCollector{
+		cache: c,
+		redisPoolHitsDesc: prometheus.NewDesc(
+			"gglib_cache_redis_pool_hits_total",
+			"number of times a free connection was found in the redis pool.",
+			nil, labels),
+		redisPoolMissesDesc: prometheus.NewDesc(
+			"gglib_cache_redis_pool_misses_total",
+			"number of times a free connection was not found in the redis pool.",
+			nil, labels),
+		redisPoolTimeoutsDesc: prometheus.NewDesc(
+			"gglib_cache_redis_pool_timeouts_total",
+			"number of times a wait for a redis pool connection timed out.",
+			nil, labels),
+		redisPoolConnsDesc: prometheus.NewDesc(
+			"gglib_cache_redis_pool_conns",
+			"redis pool connections by state: {total, idle, stale}.",
+			[]string{"state"}, labels),
+		memCacheEntriesDesc: prometheus.NewDesc(
+			"gglib_cache_mem_entries",
+			"number of entries in the in-memory tier.",
+			nil, labels),
+		memCacheHitRateDesc: prometheus.NewDesc(
+			"gglib_cache_mem_hit_rate",
+			"hit rate of the in-memory tier.",
+			nil, labels),
+		memCacheEvacuationsDesc: prometheus.NewDesc(
+			"gglib_cache_mem_evacuations_total",
+			"entries evacuated from the in-memory tier to make room for new ones.",
+			nil, labels),
+		memCacheExpirationsDesc: prometheus.NewDesc(
+			"gglib_cache_mem_expirations_total",
+			"entries expired from the in-memory tier.",
+			nil, labels),
+	}
+}
+
+func (s *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.redisPoolHitsDesc
+	ch <- s.redisPoolMissesDesc
+	ch <- s.redisPoolTimeoutsDesc
+	ch <- s.redisPoolConnsDesc
+	ch <- s.memCacheEntriesDesc
+	ch <- s.memCacheHitRateDesc
+	ch <- s.memCacheEvacuationsDesc
+	ch <- s.memCacheExpirationsDesc
+}
+
+func (s *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	if rdb := s.cache.GetRedisClient(); rdb != nil {
+		stats := rdb.PoolStats()
+		ch <- prometheus.MustNewConstMetric(s.redisPoolHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+		ch <- prometheus.MustNewConstMetric(s.redisPoolMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+		ch <- prometheus.MustNewConstMetric(s.redisPoolTimeoutsDesc, prometheus.CounterValue, float64(stats.Timeouts))
+		ch <- prometheus.MustNewConstMetric(s.redisPoolConnsDesc, prometheus.GaugeValue, float64(stats.TotalConns), "total")
+		ch <- prometheus.MustNewConstMetric(s.redisPoolConnsDesc, prometheus.GaugeValue, float64(stats.IdleConns), "idle")
+		ch <- prometheus.MustNewConstMetric(s.redisPoolConnsDesc, prometheus.GaugeValue, float64(stats.StaleConns), "stale")
+	}
+	if mem := s.cache.memCache; mem != nil {
+		ch <- prometheus.MustNewConstMetric(s.memCacheEntriesDesc, prometheus.GaugeValue, float64(mem.EntryCount()))
+		ch <- prometheus.MustNewConstMetric(s.memCacheHitRateDesc, prometheus.GaugeValue, mem.HitRate())
+		ch <- prometheus.MustNewConstMetric(s.memCacheEvacuationsDesc, prometheus.CounterValue, float64(mem.EvacuateCount()))
+		ch <- prometheus.MustNewConstMetric(s.memCacheExpirationsDesc, prometheus.CounterValue, float64(mem.ExpiredCount()))
+	}
+}
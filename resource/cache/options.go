@@ -1,6 +1,11 @@
 package cache
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/redis/go-redis/v9"
+)
 
 type Option func(*Cache)
 
@@ -10,6 +15,14 @@ func WithAppName(appName string) Option {
 	}
 }
 
+// WithName names the cache, see NewNamedCache. It does not change the env
+// prefixes, use WithRedisEnvPrefix and WithDCacheEnvPrefix for that.
+func WithName(name string) Option {
+	return func(c *Cache) {
+		c.name = name
+	}
+}
+
 func WithRedisConfig(redisCfg *RedisConfig) Option {
 	return func(c *Cache) {
 		c.redisConfig = redisCfg
@@ -37,3 +50,19 @@ func WithDCacheEnvPrefix(envPrefix string) Option {
 		c.dCacheConfig = &dcacheCfg
 	}
 }
+
+// WithRedisClient makes Init use client instead of building one from the
+// redis config. The client is closed by Stop.
+func WithRedisClient(client redis.UniversalClient) Option {
+	return func(c *Cache) {
+		c.redisClient = client
+	}
+}
+
+// WithNowFunc replaces time.Now for expirations of the in-memory tier and of
+// Typed values, usually used for testing.
+func WithNowFunc(now func() time.Time) Option {
+	return func(c *Cache) {
+		c.now = now
+	}
+}
@@ -3,9 +3,13 @@ package cache
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
@@ -14,7 +18,12 @@ import (
 	"github.com/ggsrc/gglib/goodns"
 )
 
-func (cache *Cache) newRedisClientWithConfig() redis.UniversalClient {
+const (
+	connectInitialBackoff = 100 * time.Millisecond
+	connectMaxBackoff     = 2 * time.Second
+)
+
+func (cache *Cache) newRedisClientWithConfig(ctx context.Context) (redis.UniversalClient, error) {
 	c := cache.redisConfig
 	masker := mask.NewMasker()
 	masker.RegisterMaskStringFunc(mask.MaskTypeFilled, masker.MaskFilledString)
@@ -23,63 +32,178 @@ func (cache *Cache) newRedisClientWithConfig() redis.UniversalClient {
 	conf, _ := masker.Mask(c)
 	log.Warn().Msgf("Redis Config: %+v", conf)
 
+	tlsConfig, err := newTLSConfig(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build redis tls config")
+	}
+
 	var redisClient redis.UniversalClient
 	if c.IsClusterMode {
-		redisClient = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:        c.ClusterAddrs,
-			MaxRedirects: c.ClusterMaxRedirects,
-			ReadTimeout:  c.ReadTimeout,
-			PoolSize:     c.PoolSize,
-			Password:     c.Password,
-		})
+		redisClient = redis.NewClusterClient(newClusterOptions(c, tlsConfig))
 	} else if !c.IsFailover {
-		option := &redis.Options{
-			Addr:        fmt.Sprintf("%s:%d", c.Host, c.Port),
-			ReadTimeout: c.ReadTimeout,
-			PoolSize:    c.PoolSize,
-			Password:    c.Password,
-		}
-		if c.IsElastiCache {
-			// Elasticache cert cannot be applied to cname record we use
-			option.TLSConfig = &tls.Config{
-				// nolint: gosec
-				InsecureSkipVerify: true,
-			}
-		}
-		redisClient = redis.NewClient(option)
+		redisClient = redis.NewClient(newOptions(c, tlsConfig))
 	} else {
-		dnsCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		ips, err := goodns.LookupA(dnsCtx, c.Host, true)
+		var addrs []string
+		err := cache.retryConnect(ctx, "resolve sentinel host", func(ctx context.Context) (err error) {
+			addrs, err = cache.resolveSentinels(ctx)
+			return err
+		})
 		if err != nil {
-			panic("failed to exec goodns.LookupA: " + err.Error())
-		}
-		for _, ip := range ips {
-			log.Info().Msgf("%s Has A: %s\n", c.Host, ip.String())
+			return nil, err
 		}
-
-		addrs := make([]string, len(ips))
-		for i, ip := range ips {
-			addrs[i] = fmt.Sprintf("%s:%d", ip.String(), c.Port)
-		}
-		redisClient = redis.NewFailoverClient(&redis.FailoverOptions{
-			MasterName:    "master",
-			SentinelAddrs: addrs,
-			Password:      c.Password,
-			PoolSize:      c.PoolSize,
-			ReadTimeout:   c.ReadTimeout,
-		})
+		cache.sentinelAddrs = addrs
+		redisClient = cache.newFailoverClient(addrs, tlsConfig)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	return instrument(redisClient)
+}
+
+// resolveSentinels returns the sorted addresses of every A record of the
+// sentinel host.
+func (cache *Cache) resolveSentinels(ctx context.Context) ([]string, error) {
+	c := cache.redisConfig
+	dnsCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
+	ips, err := goodns.LookupA(dnsCtx, c.Host, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve sentinel host %s", c.Host)
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("no A record found for sentinel host %s", c.Host)
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		log.Info().Msgf("%s Has A: %s\n", c.Host, ip.String())
+		addrs[i] = fmt.Sprintf("%s:%d", ip.String(), c.Port)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func (cache *Cache) newFailoverClient(addrs []string, tlsConfig *tls.Config) redis.UniversalClient {
+	return redis.NewFailoverClient(newFailoverOptions(cache.redisConfig, addrs, tlsConfig))
+}
+
+func newOptions(c *RedisConfig, tlsConfig *tls.Config) *redis.Options {
+	return &redis.Options{
+		Addr:         fmt.Sprintf("%s:%d", c.Host, c.Port),
+		DB:           c.DB,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		Username:     c.Username,
+		Password:     c.Password,
+		TLSConfig:    tlsConfig,
+	}
+}
+
+func newClusterOptions(c *RedisConfig, tlsConfig *tls.Config) *redis.ClusterOptions {
+	return &redis.ClusterOptions{
+		Addrs:        c.ClusterAddrs,
+		MaxRedirects: c.ClusterMaxRedirects,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		Username:     c.Username,
+		Password:     c.Password,
+		TLSConfig:    tlsConfig,
+	}
+}
+
+func newFailoverOptions(c *RedisConfig, addrs []string, tlsConfig *tls.Config) *redis.FailoverOptions {
+	return &redis.FailoverOptions{
+		MasterName:       c.SentinelMasterName,
+		SentinelAddrs:    addrs,
+		SentinelUsername: c.SentinelUsername,
+		SentinelPassword: c.SentinelPassword,
+		DB:               c.DB,
+		Username:         c.Username,
+		Password:         c.Password,
+		PoolSize:         c.PoolSize,
+		MinIdleConns:     c.MinIdleConns,
+		DialTimeout:      c.DialTimeout,
+		ReadTimeout:      c.ReadTimeout,
+		WriteTimeout:     c.WriteTimeout,
+		TLSConfig:        tlsConfig,
+	}
+}
+
+func instrument(redisClient redis.UniversalClient) (redis.UniversalClient, error) {
 	if err := redisotel.InstrumentTracing(redisClient); err != nil {
-		log.Fatal().Err(err).Msgf("failed to InstrumentTracing to redis; config: %v", conf)
+		_ = redisClient.Close()
+		return nil, errors.Wrap(err, "failed to InstrumentTracing to redis")
 	}
 	if err := redisotel.InstrumentMetrics(redisClient); err != nil {
-		log.Fatal().Err(err).Msgf("failed to InstrumentMetrics to redis; config: %v", conf)
+		_ = redisClient.Close()
+		return nil, errors.Wrap(err, "failed to InstrumentMetrics to redis")
 	}
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Fatal().Err(err).Msgf("failed to connect to redis; config: %v", conf)
+	return redisClient, nil
+}
+
+// connectRedis pings Redis with exponential backoff until it answers or ctx
+// is done. ConnectTimeout applies if ctx has no deadline.
+func (cache *Cache) connectRedis(ctx context.Context) error {
+	return cache.retryConnect(ctx, "connect to redis", func(ctx context.Context) error {
+		return cache.GetRedisClient().Ping(ctx).Err()
+	})
+}
+
+// retryConnect calls f with exponential backoff until it succeeds or ctx is
+// done. ConnectTimeout applies if ctx has no deadline.
+func (cache *Cache) retryConnect(ctx context.Context, what string, f func(ctx context.Context) error) error {
+	if _, ok := ctx.Deadline(); !ok && cache.redisConfig.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cache.redisConfig.ConnectTimeout)
+		defer cancel()
+	}
+	backoff := connectInitialBackoff
+	for {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Ctx(ctx).Warn().Err(err).Msgf("failed to %s, retrying in %s", what, backoff)
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "failed to %s", what)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, connectMaxBackoff)
+	}
+}
+
+// newTLSConfig returns nil if TLS is not enabled. Certificates are verified
+// unless TLSInsecureSkipVerify is set.
+func newTLSConfig(c *RedisConfig) (*tls.Config, error) {
+	if !c.TLSEnabled && !c.IsElastiCache {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.TLSServerName,
+		// nolint: gosec
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+	if c.TLSCAFile != "" {
+		ca, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tls ca file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificate found in %s", c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load tls client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-	return redisClient
+	return tlsConfig, nil
 }
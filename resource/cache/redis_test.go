@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key to dir.
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis.internal"},
+		DNSNames:              []string{"redis.internal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		config     RedisConfig
+		wantNil    bool
+		wantErr    string
+		skipVerify bool
+		serverName string
+		withRoots  bool
+		withCert   bool
+	}{
+		{name: "disabled", config: RedisConfig{}, wantNil: true},
+		{name: "enabled", config: RedisConfig{TLSEnabled: true}},
+		{name: "elasticache verifies by default", config: RedisConfig{IsElastiCache: true}},
+		{
+			name:       "elasticache with server name",
+			config:     RedisConfig{IsElastiCache: true, TLSServerName: "master.cache.amazonaws.com"},
+			serverName: "master.cache.amazonaws.com",
+		},
+		{
+			name:       "insecure skip verify",
+			config:     RedisConfig{IsElastiCache: true, TLSInsecureSkipVerify: true},
+			skipVerify: true,
+		},
+		{name: "ca file", config: RedisConfig{TLSEnabled: true, TLSCAFile: certFile}, withRoots: true},
+		{name: "missing ca file", config: RedisConfig{TLSEnabled: true, TLSCAFile: filepath.Join(dir, "missing.pem")}, wantErr: "failed to read tls ca file"},
+		{name: "ca file without certificate", config: RedisConfig{TLSEnabled: true, TLSCAFile: notPEM}, wantErr: "no certificate found"},
+		{name: "client certificate", config: RedisConfig{TLSEnabled: true, TLSCertFile: certFile, TLSKeyFile: keyFile}, withCert: true},
+		{name: "client certificate without key", config: RedisConfig{TLSEnabled: true, TLSCertFile: certFile}, wantErr: "failed to load tls client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTLSConfig(&tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newTLSConfig failed: %v", err)
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("Expected no tls config, got %+v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("Expected a tls config")
+			}
+			if got.MinVersion != tls.VersionTLS12 {
+				t.Errorf("Expected TLS 1.2 at least, got %x", got.MinVersion)
+			}
+			if got.InsecureSkipVerify != tt.skipVerify {
+				t.Errorf("Expected InsecureSkipVerify %v, got %v", tt.skipVerify, got.InsecureSkipVerify)
+			}
+			if got.ServerName != tt.serverName {
+				t.Errorf("Expected server name %q, got %q", tt.serverName, got.ServerName)
+			}
+			if (got.RootCAs != nil) != tt.withRoots {
+				t.Errorf("Expected custom roots %v, got %v", tt.withRoots, got.RootCAs != nil)
+			}
+			if (len(got.Certificates) == 1) != tt.withCert {
+				t.Errorf("Expected client certificate %v, got %d certificates", tt.withCert, len(got.Certificates))
+			}
+		})
+	}
+}
+
+func TestRedisOptions(t *testing.T) {
+	c := &RedisConfig{
+		Host:                "redis.internal",
+		Port:                6380,
+		DB:                  3,
+		Username:            "app",
+		Password:            "secret",
+		ClusterAddrs:        []string{"node1:6379", "node2:6379"},
+		ClusterMaxRedirects: 5,
+		SentinelMasterName:  "mymaster",
+		SentinelUsername:    "sentinel",
+		SentinelPassword:    "sentinel-secret",
+		DialTimeout:         time.Second,
+		ReadTimeout:         2 * time.Second,
+		WriteTimeout:        3 * time.Second,
+		PoolSize:            20,
+		MinIdleConns:        4,
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	opts := newOptions(c, tlsConfig)
+	if opts.Addr != "redis.internal:6380" || opts.DB != 3 || opts.Username != "app" || opts.Password != "secret" {
+		t.Errorf("Unexpected connection options %s@%s/%d", opts.Username, opts.Addr, opts.DB)
+	}
+	if opts.DialTimeout != time.Second || opts.ReadTimeout != 2*time.Second || opts.WriteTimeout != 3*time.Second {
+		t.Errorf("Unexpected timeouts %s, %s, %s", opts.DialTimeout, opts.ReadTimeout, opts.WriteTimeout)
+	}
+	if opts.PoolSize != 20 || opts.MinIdleConns != 4 || opts.TLSConfig != tlsConfig {
+		t.Errorf("Unexpected pool or tls options %+v", opts)
+	}
+
+	cluster := newClusterOptions(c, tlsConfig)
+	if len(cluster.Addrs) != 2 || cluster.MaxRedirects != 5 || cluster.Username != "app" || cluster.Password != "secret" {
+		t.Errorf("Unexpected cluster options %+v", cluster)
+	}
+	if cluster.PoolSize != 20 || cluster.MinIdleConns != 4 || cluster.TLSConfig != tlsConfig {
+		t.Errorf("Unexpected cluster pool or tls options %+v", cluster)
+	}
+
+	failover := newFailoverOptions(c, []string{"10.0.0.1:26379"}, tlsConfig)
+	if failover.MasterName != "mymaster" || len(failover.SentinelAddrs) != 1 || failover.SentinelAddrs[0] != "10.0.0.1:26379" {
+		t.Errorf("Unexpected sentinel options %+v", failover)
+	}
+	if failover.SentinelUsername != "sentinel" || failover.SentinelPassword != "sentinel-secret" {
+		t.Errorf("Unexpected sentinel credentials %s:%s", failover.SentinelUsername, failover.SentinelPassword)
+	}
+	if failover.DB != 3 || failover.Username != "app" || failover.Password != "secret" || failover.TLSConfig != tlsConfig {
+		t.Errorf("Unexpected master options %+v", failover)
+	}
+	if failover.PoolSize != 20 || failover.MinIdleConns != 4 || failover.ReadTimeout != 2*time.Second {
+		t.Errorf("Unexpected failover pool options %+v", failover)
+	}
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const refreshLockSuffix = ":refresh"
+
+// refreshAsync reloads key in the background unless a refresh of key is
+// already running in this process or, through a Redis lock, on another replica.
+func (t *Typed[T]) refreshAsync(ctx context.Context, key string) {
+	if t.loader == nil {
+		return
+	}
+	if _, running := t.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), t.opts.refreshTimeout)
+	started := t.cache.goBackground(func() {
+		defer func() {
+			cancel()
+			t.refreshing.Delete(key)
+		}()
+		result := "success"
+		if err := t.refresh(ctx, key); err != nil {
+			log.Ctx(ctx).Err(err).Msgf("failed to refresh cache key %s", t.Key(key))
+			result = "error"
+		}
+		typedRefreshes.WithLabelValues(t.cache.appName, t.cache.name, t.namespace, result).Inc()
+	})
+	if !started {
+		cancel()
+		t.refreshing.Delete(key)
+	}
+}
+
+func (t *Typed[T]) refresh(ctx context.Context, key string) error {
+	if rdb := t.cache.GetRedisClient(); rdb != nil {
+		lockKey := t.cache.Namespace() + ":" + t.Key(key) + refreshLockSuffix
+		token, err := newLockToken()
+		if err != nil {
+			return err
+		}
+		acquired, err := rdb.SetNX(ctx, lockKey, token, t.opts.refreshTimeout).Result()
+		if err != nil {
+			return errors.Wrap(err, "failed to acquire refresh lock")
+		}
+		if !acquired {
+			return nil
+		}
+		// the lock may have expired and been taken by another replica.
+		defer releaseLockScript.Run(context.WithoutCancel(ctx), rdb, []string{lockKey}, token)
+	}
+
+	b, ttl, err := t.load(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return t.Delete(ctx, key)
+	}
+	if err != nil {
+		return err
+	}
+	dc, err := t.dCache()
+	if err != nil {
+		return err
+	}
+	if err = dc.Set(ctx, t.Key(key), b, ttl); err != nil {
+		return err
+	}
+	return t.cache.evictReplicas(ctx, t.Key(key))
+}
+
+// goBackground runs f on a goroutine that Stop waits for. It returns false
+// without running f once the cache is stopping.
+func (c *Cache) goBackground(f func()) bool {
+	c.bgMu.Lock()
+	defer c.bgMu.Unlock()
+	if c.stopping {
+		return false
+	}
+	c.bgWG.Add(1)
+	go func() {
+		defer c.bgWG.Done()
+		f()
+	}()
+	return true
+}
+
+// waitBackground stops accepting background work and waits for the running
+// one until ctx is done.
+func (c *Cache) waitBackground(ctx context.Context) error {
+	c.bgMu.Lock()
+	c.stopping = true
+	c.bgMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.bgWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "timed out waiting for background cache refreshes")
+	}
+}
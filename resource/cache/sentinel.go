@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// sentinelSwapGrace is how long a replaced failover client stays open so
+// that commands already running on it can finish.
+const sentinelSwapGrace = 10 * time.Second
+
+// startSentinelWatch re-resolves the sentinel host every
+// SentinelResolveInterval in failover mode.
+func (c *Cache) startSentinelWatch() {
+	if !c.redisConfig.IsFailover || c.redisConfig.SentinelResolveInterval <= 0 || c.sentinelAddrs == nil {
+		return
+	}
+	sentinelAddrsGauge.WithLabelValues(c.appName, c.name).Set(float64(len(c.sentinelAddrs)))
+	ctx, cancel := context.WithCancel(context.Background())
+	c.watchCancel = cancel
+	c.watchWG.Add(1)
+	go func() {
+		defer c.watchWG.Done()
+		ticker := time.NewTicker(c.redisConfig.SentinelResolveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.resolveAndSwap(ctx); err != nil && ctx.Err() == nil {
+					sentinelResolveErrors.WithLabelValues(c.appName, c.name).Inc()
+					log.Ctx(ctx).Err(err).Msg("failed to refresh sentinel addresses")
+				}
+			}
+		}
+	}()
+}
+
+func (c *Cache) stopSentinelWatch() {
+	if c.watchCancel != nil {
+		c.watchCancel()
+	}
+	c.watchWG.Wait()
+}
+
+// resolveAndSwap rebuilds the failover client and dcache on it if the
+// sentinel host resolves to a different address set. The current client is
+// kept if the new one cannot reach the master.
+func (c *Cache) resolveAndSwap(ctx context.Context) error {
+	addrs, err := c.resolveSentinels(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(addrs, c.sentinelAddrs) {
+		return nil
+	}
+	log.Ctx(ctx).Warn().Msgf("sentinel addresses of %s changed from %v to %v", c.redisConfig.Host, c.sentinelAddrs, addrs)
+
+	tlsConfig, err := newTLSConfig(c.redisConfig)
+	if err != nil {
+		return errors.Wrap(err, "failed to build redis tls config")
+	}
+	client, err := instrument(c.newFailoverClient(addrs, tlsConfig))
+	if err != nil {
+		return err
+	}
+	if err = c.swapClient(ctx, client); err != nil {
+		return errors.Wrapf(err, "failed to switch to redis master %s through %v", c.redisConfig.SentinelMasterName, addrs)
+	}
+	c.sentinelAddrs = addrs
+	sentinelChanges.WithLabelValues(c.appName, c.name).Inc()
+	sentinelAddrsGauge.WithLabelValues(c.appName, c.name).Set(float64(len(addrs)))
+	return nil
+}
+
+// swapClient replaces the redis client and the dcache on it with client,
+// which is closed instead if it cannot reach Redis. The old client stays
+// open for sentinelSwapGrace so that commands already running on it, and on
+// the old dcache, can finish.
+func (c *Cache) swapClient(ctx context.Context, client redis.UniversalClient) error {
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	oldClient, oldDCache := c.redisClient, c.dCache
+	// dcache metrics are registered globally, so the old dcache must
+	// unregister them before the new one registers its own. The lock keeps
+	// readers from getting the old dcache once it is closed.
+	oldDCache.Close()
+	dCache, err := c.newDCacheWithConfig(client)
+	if err != nil {
+		// keep serving from the old client
+		if restored, restoreErr := c.newDCacheWithConfig(oldClient); restoreErr == nil {
+			c.dCache = restored
+		}
+		c.mu.Unlock()
+		_ = client.Close()
+		return err
+	}
+	c.redisClient = client
+	c.dCache = dCache
+	c.mu.Unlock()
+	c.available.Store(true)
+
+	if bus := c.getBus(); bus != nil {
+		if err = bus.restart(); err != nil {
+			log.Ctx(ctx).Err(err).Msg("failed to restart cache invalidation on the new redis client")
+		}
+	}
+	c.goBackground(func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(sentinelSwapGrace):
+		}
+		if err := oldClient.Close(); err != nil {
+			log.Err(err).Msg("failed to close replaced redis client")
+		}
+	})
+	return nil
+}
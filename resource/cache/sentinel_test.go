@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newSwapTestCache(t *testing.T, addr string) *Cache {
+	t.Helper()
+	c := NewCacheWithOptions(
+		WithAppName("swaptest"),
+		WithRedisConfig(&RedisConfig{}),
+		WithDCacheConfig(&DCacheConfig{
+			ReadInterval:     500 * time.Millisecond,
+			InMemCacheSize:   1024 * 1024,
+			InvalidationMode: InvalidationModePubSub,
+		}),
+		WithRedisClient(redis.NewClient(&redis.Options{Addr: addr})),
+	)
+	ctx := context.Background()
+	if err := c.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := c.Stop(ctx); err != nil {
+			t.Errorf("Stop failed: %v", err)
+		}
+	})
+	return c
+}
+
+func TestCache_SwapClient(t *testing.T) {
+	oldSrv, newSrv := miniredis.RunT(t), miniredis.RunT(t)
+	c := newSwapTestCache(t, oldSrv.Addr())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	oldClient, oldDCache := c.GetRedisClient(), c.GetDCache()
+	client := redis.NewClient(&redis.Options{Addr: newSrv.Addr()})
+	if err := c.swapClient(ctx, client); err != nil {
+		t.Fatalf("swapClient failed: %v", err)
+	}
+	if c.GetRedisClient() != client || c.GetDCache() == oldDCache {
+		t.Fatal("Expected the client and dcache to be replaced")
+	}
+	if err := c.GetDCache().Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !newSrv.Exists("swaptest:{k}") || oldSrv.Exists("swaptest:{k}") {
+		t.Errorf("Expected the new dcache to write to the new redis, keys: %v", newSrv.Keys())
+	}
+
+	// the invalidation bus listens on the new client
+	deadline := time.Now().Add(2 * time.Second)
+	for newSrv.PubSubNumSub(c.Namespace() + ":cache-invalidation")[c.Namespace()+":cache-invalidation"] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the invalidation bus to subscribe on the new redis")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the old client is closed once the grace period ends, here when ctx is done
+	cancel()
+	deadline = time.Now().Add(2 * time.Second)
+	for oldClient.Ping(context.Background()).Err() != redis.ErrClosed {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the old client to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCache_SwapClientKeepsCurrentOnFailure(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c := newSwapTestCache(t, srv.Addr())
+	ctx := context.Background()
+
+	current, dCache := c.GetRedisClient(), c.GetDCache()
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	if err := c.swapClient(ctx, client); err == nil {
+		t.Fatal("Expected swapClient to fail on an unreachable redis")
+	}
+	if c.GetRedisClient() != current || c.GetDCache() != dCache {
+		t.Fatal("Expected the current client and dcache to be kept")
+	}
+	if err := c.GetDCache().Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Errorf("Expected the current dcache to keep working, got %v", err)
+	}
+}
+
+func TestCache_RetryConnect(t *testing.T) {
+	c := &Cache{redisConfig: &RedisConfig{ConnectTimeout: time.Second}}
+	ctx := context.Background()
+
+	calls := 0
+	err := c.retryConnect(ctx, "resolve sentinel host", func(ctx context.Context) error {
+		if calls++; calls < 3 {
+			return errors.New("no such host")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third attempt, got %v after %d attempts", err, calls)
+	}
+
+	c.redisConfig.ConnectTimeout = 250 * time.Millisecond
+	start := time.Now()
+	err = c.retryConnect(ctx, "resolve sentinel host", func(ctx context.Context) error {
+		return errors.New("no such host")
+	})
+	if err == nil || err.Error() != "failed to resolve sentinel host: no such host" {
+		t.Errorf("Expected the last error once ConnectTimeout is over, got %v", err)
+	}
+	if d := time.Since(start); d < 250*time.Millisecond || d > time.Second {
+		t.Errorf("Expected to give up after ConnectTimeout, got %s", d)
+	}
+}
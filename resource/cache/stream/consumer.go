@@ -0,0 +1,373 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ggsrc/gglib/resource/cache"
+)
+
+const name = "github.com/ggsrc/gglib/resource/cache/stream"
+
+var tracer = otel.Tracer(name)
+
+const readRetryDelay = time.Second
+
+// Handler processes a message. Returning nil acknowledges it; otherwise it
+// stays pending and is redelivered after Config.MinIdle.
+type Handler func(ctx context.Context, msg *Message) error
+
+type Config struct {
+	Group    string   `required:"true"`
+	Streams  []string `required:"true"`
+	Consumer string   `default:""` // hostname by default
+	// StartID is where a newly created group starts reading: $ for new messages only, 0 for the whole stream.
+	StartID          string        `default:"$"`
+	Concurrency      int           `default:"8"`
+	BatchSize        int64         `default:"16"`
+	Block            time.Duration `default:"5s"`
+	MinIdle          time.Duration `default:"1m"`  // pending messages idle longer are reclaimed
+	ClaimInterval    time.Duration `default:"30s"` // how often pending messages are reclaimed
+	MaxDeliveries    int64         `default:"5"`   // then messages go to the dead-letter stream
+	DeadLetterSuffix string        `default:":dlq"`
+}
+
+// Consumer is a resource running a consumer group over Config.Streams with
+// the Redis client of a cache.Cache, which must be initialized first.
+type Consumer struct {
+	cache   *cache.Cache
+	conf    *Config
+	handler Handler
+
+	initialized bool
+	cancel      context.CancelFunc
+	stopOnce    sync.Once
+	readers     sync.WaitGroup
+	workers     sync.WaitGroup
+	workerCtx   context.Context
+	stopWorkers context.CancelFunc
+	jobs        chan *Message
+	lastErr     atomic.Pointer[error]
+}
+
+func NewConsumerWithEnvPrefix(c *cache.Cache, envPrefix string, handler Handler) *Consumer {
+	conf := &Config{}
+	envconfig.MustProcess(envPrefix, conf)
+	return NewConsumer(c, conf, handler)
+}
+
+func NewConsumer(c *cache.Cache, conf *Config, handler Handler) *Consumer {
+	if c == nil || conf == nil || handler == nil {
+		panic("cache, conf and handler cannot be nil")
+	}
+	if conf.Consumer == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			panic("failed to get hostname: " + err.Error())
+		}
+		conf.Consumer = hostname
+	}
+	conf.Concurrency = max(conf.Concurrency, 1)
+	return &Consumer{
+		cache:   c,
+		conf:    conf,
+		handler: handler,
+	}
+}
+
+func (c *Consumer) Name() string {
+	return "stream_consumer_" + c.conf.Group
+}
+
+// client returns the current client of the cache, which is replaced when
+// the sentinel addresses change in failover mode.
+func (c *Consumer) client() redis.UniversalClient {
+	return c.cache.GetRedisClient()
+}
+
+// Init creates the consumer group on every stream if it does not exist.
+func (c *Consumer) Init(ctx context.Context) error {
+	client := c.client()
+	if client == nil {
+		return errors.New("cache must be initialized before stream consumer")
+	}
+	for _, stream := range c.conf.Streams {
+		err := client.XGroupCreateMkStream(ctx, stream, c.conf.Group, c.conf.StartID).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return errors.Wrapf(err, "failed to create group %s on %s", c.conf.Group, stream)
+		}
+	}
+	c.initialized = true
+	return nil
+}
+
+func (c *Consumer) Start(ctx context.Context) error {
+	if !c.initialized {
+		return errors.New("stream consumer not initialized")
+	}
+	// readers and workers outlive ctx, they are stopped by Stop
+	readCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c.cancel = cancel
+	c.workerCtx, c.stopWorkers = context.WithCancel(context.WithoutCancel(ctx))
+	c.jobs = make(chan *Message)
+
+	for range c.conf.Concurrency {
+		c.workers.Add(1)
+		go c.work()
+	}
+	for _, stream := range c.conf.Streams {
+		c.readers.Add(2)
+		go c.read(readCtx, stream)
+		go c.reclaim(readCtx, stream)
+	}
+	return nil
+}
+
+// Stop stops reading and waits for dispatched messages to be handled until
+// ctx is done, then cancels the handlers. It may be called more than once.
+func (c *Consumer) Stop(ctx context.Context) error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.stopOnce.Do(func() {
+		c.cancel()
+		c.readers.Wait()
+		close(c.jobs)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		c.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		c.stopWorkers()
+		return nil
+	case <-ctx.Done():
+		c.stopWorkers()
+		return errors.Wrap(ctx.Err(), "timed out draining stream consumer")
+	}
+}
+
+// OK reports the last error of reading the streams, if the latest read failed.
+func (c *Consumer) OK(ctx context.Context) error {
+	if err := c.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (c *Consumer) read(ctx context.Context, stream string) {
+	defer c.readers.Done()
+	for {
+		res, err := c.client().XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.conf.Group,
+			Consumer: c.conf.Consumer,
+			Streams:  []string{stream, ">"},
+			Count:    c.conf.BatchSize,
+			Block:    c.conf.Block,
+		}).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			c.setErr(errors.Wrapf(err, "failed to read stream %s", stream))
+			log.Err(err).Msgf("failed to read stream %s of group %s", stream, c.conf.Group)
+			if !sleep(ctx, readRetryDelay) {
+				return
+			}
+			continue
+		}
+		c.setErr(nil)
+		for _, s := range res {
+			for _, msg := range s.Messages {
+				if !c.dispatch(ctx, &Message{Stream: s.Stream, ID: msg.ID, Values: msg.Values, Deliveries: 1}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// reclaim takes over messages pending for longer than MinIdle, e.g. after a
+// handler failure or a crashed consumer, and dead-letters those delivered
+// more than MaxDeliveries times.
+func (c *Consumer) reclaim(ctx context.Context, stream string) {
+	defer c.readers.Done()
+	for sleep(ctx, c.conf.ClaimInterval) {
+		start := "0-0"
+		for {
+			msgs, next, err := c.client().XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   stream,
+				Group:    c.conf.Group,
+				Consumer: c.conf.Consumer,
+				MinIdle:  c.conf.MinIdle,
+				Start:    start,
+				Count:    c.conf.BatchSize,
+			}).Result()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Err(err).Msgf("failed to reclaim pending messages of %s", stream)
+				}
+				break
+			}
+			if len(msgs) > 0 && !c.dispatchClaimed(ctx, stream, msgs) {
+				return
+			}
+			if next == "0-0" || len(msgs) == 0 {
+				break
+			}
+			start = next
+		}
+	}
+}
+
+func (c *Consumer) dispatchClaimed(ctx context.Context, stream string, msgs []redis.XMessage) bool {
+	deliveries := c.deliveries(ctx, stream, msgs)
+	for _, msg := range msgs {
+		m := &Message{Stream: stream, ID: msg.ID, Values: msg.Values, Deliveries: deliveries[msg.ID]}
+		if c.conf.MaxDeliveries > 0 && m.Deliveries > c.conf.MaxDeliveries {
+			c.deadLetter(ctx, m)
+			continue
+		}
+		if !c.dispatch(ctx, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// deliveries returns the delivery counts of msgs, claimed by this consumer.
+// Each message is looked up by its ID so that the pending entries of other
+// consumers in between are not counted against the XPENDING limit.
+func (c *Consumer) deliveries(ctx context.Context, stream string, msgs []redis.XMessage) map[string]int64 {
+	cmds := make([]*redis.XPendingExtCmd, len(msgs))
+	_, err := c.client().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, msg := range msgs {
+			cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream:   stream,
+				Group:    c.conf.Group,
+				Start:    msg.ID,
+				End:      msg.ID,
+				Count:    1,
+				Consumer: c.conf.Consumer,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("failed to read delivery counts of %s", stream)
+	}
+	deliveries := make(map[string]int64, len(msgs))
+	for _, cmd := range cmds {
+		for _, p := range cmd.Val() {
+			deliveries[p.ID] = p.RetryCount
+		}
+	}
+	return deliveries
+}
+
+func (c *Consumer) deadLetter(ctx context.Context, m *Message) {
+	values := make(map[string]any, len(m.Values)+3)
+	for k, v := range m.Values {
+		values[k] = v
+	}
+	values[FieldDeadLetterStream] = m.Stream
+	values[FieldDeadLetterID] = m.ID
+	values[FieldDeadLetterDeliveries] = m.Deliveries
+	dlq := m.Stream + c.conf.DeadLetterSuffix
+	_, err := c.client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: dlq, Values: values})
+		pipe.XAck(ctx, m.Stream, c.conf.Group, m.ID)
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("failed to move message %s of %s to %s", m.ID, m.Stream, dlq)
+		return
+	}
+	log.Warn().Msgf("moved message %s of %s to %s after %d deliveries", m.ID, m.Stream, dlq, m.Deliveries)
+}
+
+func (c *Consumer) dispatch(ctx context.Context, m *Message) bool {
+	select {
+	case c.jobs <- m:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *Consumer) work() {
+	defer c.workers.Done()
+	for m := range c.jobs {
+		c.handle(m)
+	}
+}
+
+func (c *Consumer) handle(m *Message) {
+	ctx, values := extractTrace(c.workerCtx, m.Values)
+	m.Values = values
+	ctx, span := tracer.Start(ctx, "stream.consume "+m.Stream,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "redis"),
+			attribute.String("messaging.destination.name", m.Stream),
+			attribute.String("messaging.consumer.group.name", c.conf.Group),
+			attribute.String("messaging.message.id", m.ID),
+			attribute.Int64("messaging.message.deliveries", m.Deliveries),
+		))
+	defer span.End()
+
+	err := c.safeHandle(ctx, m)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Ctx(ctx).Err(err).Msgf("failed to handle message %s of %s", m.ID, m.Stream)
+		return
+	}
+	if err = c.client().XAck(context.WithoutCancel(ctx), m.Stream, c.conf.Group, m.ID).Err(); err != nil {
+		log.Ctx(ctx).Err(err).Msgf("failed to ack message %s of %s", m.ID, m.Stream)
+	}
+}
+
+func (c *Consumer) safeHandle(ctx context.Context, m *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("[panic] %v", r)
+		}
+	}()
+	return c.handler(ctx, m)
+}
+
+func (c *Consumer) setErr(err error) {
+	if err == nil {
+		c.lastErr.Store(nil)
+		return
+	}
+	c.lastErr.Store(&err)
+}
+
+// sleep waits for d, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
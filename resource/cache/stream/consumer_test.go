@@ -0,0 +1,216 @@
+package stream_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ggsrc/gglib/resource/cache/cachetest"
+	"github.com/ggsrc/gglib/resource/cache/stream"
+)
+
+func startConsumer(t *testing.T, fake *cachetest.Fake, conf *stream.Config, handler stream.Handler) *stream.Consumer {
+	t.Helper()
+	ctx := context.Background()
+	consumer := stream.NewConsumer(fake.Cache, conf, handler)
+	if err := consumer.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if err := consumer.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() {
+		stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := consumer.Stop(stopCtx); err != nil {
+			t.Errorf("Stop failed: %v", err)
+		}
+	})
+	return consumer
+}
+
+func TestConsumer_HandlesAndAcks(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	received := make(chan *stream.Message, 10)
+	startConsumer(t, fake, &stream.Config{
+		Group:         "workers",
+		Streams:       []string{"events"},
+		Consumer:      "c1",
+		StartID:       "$",
+		Concurrency:   2,
+		BatchSize:     10,
+		Block:         50 * time.Millisecond,
+		MinIdle:       time.Minute,
+		ClaimInterval: time.Minute,
+		MaxDeliveries: 3,
+	}, func(ctx context.Context, msg *stream.Message) error {
+		received <- msg
+		return nil
+	})
+
+	id, err := stream.Publish(ctx, fake.GetRedisClient(), "events", map[string]any{"user": "1"})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.ID != id || msg.Values["user"] != "1" {
+			t.Errorf("Unexpected message: %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected message to be handled")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		pending, err := fake.GetRedisClient().XPending(ctx, "events", "workers").Result()
+		if err != nil {
+			t.Fatalf("XPending failed: %v", err)
+		}
+		if pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected message to be acked, %d pending", pending.Count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumer_DeadLettersAfterMaxDeliveries(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	deliveries := 0
+	startConsumer(t, fake, &stream.Config{
+		Group:            "workers",
+		Streams:          []string{"events"},
+		Consumer:         "c1",
+		StartID:          "$",
+		Concurrency:      1,
+		BatchSize:        10,
+		Block:            50 * time.Millisecond,
+		MinIdle:          0,
+		ClaimInterval:    20 * time.Millisecond,
+		MaxDeliveries:    2,
+		DeadLetterSuffix: ":dlq",
+	}, func(ctx context.Context, msg *stream.Message) error {
+		mu.Lock()
+		deliveries++
+		mu.Unlock()
+		return errors.New("boom")
+	})
+
+	id, err := stream.Publish(ctx, fake.GetRedisClient(), "events", map[string]any{"user": "1"})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		msgs, err := fake.GetRedisClient().XRange(ctx, "events:dlq", "-", "+").Result()
+		if err != nil {
+			t.Fatalf("XRange failed: %v", err)
+		}
+		if len(msgs) == 1 {
+			if msgs[0].Values[stream.FieldDeadLetterID] != id {
+				t.Errorf("Unexpected dead letter: %+v", msgs[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected message to be dead-lettered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if deliveries != 2 {
+		t.Errorf("Expected 2 deliveries before dead-lettering, got %d", deliveries)
+	}
+}
+
+func TestConsumer_DeadLettersAmongOtherConsumersPending(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+	rdb := fake.GetRedisClient()
+
+	if err := rdb.XGroupCreateMkStream(ctx, "events", "workers", "$").Err(); err != nil {
+		t.Fatalf("XGroupCreate failed: %v", err)
+	}
+	var ids []string
+	for i := range 7 {
+		id, err := stream.Publish(ctx, rdb, "events", map[string]any{"n": i})
+		if err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	// every message is pending on c2, the first and last ones idle and
+	// delivered too often, the ones in between fresh.
+	if err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "workers", Consumer: "c2", Streams: []string{"events", ">"},
+	}).Err(); err != nil {
+		t.Fatalf("XReadGroup failed: %v", err)
+	}
+	first, last := ids[0], ids[len(ids)-1]
+	if err := rdb.Do(ctx, "XCLAIM", "events", "workers", "c2", 0, first, last,
+		"IDLE", time.Hour.Milliseconds(), "RETRYCOUNT", 5).Err(); err != nil {
+		t.Fatalf("XCLAIM failed: %v", err)
+	}
+
+	handled := make(chan string, len(ids))
+	consumer := startConsumer(t, fake, &stream.Config{
+		Group:            "workers",
+		Streams:          []string{"events"},
+		Consumer:         "c1",
+		StartID:          "$",
+		Concurrency:      1,
+		BatchSize:        10,
+		Block:            50 * time.Millisecond,
+		MinIdle:          time.Minute,
+		ClaimInterval:    20 * time.Millisecond,
+		MaxDeliveries:    3,
+		DeadLetterSuffix: ":dlq",
+	}, func(ctx context.Context, msg *stream.Message) error {
+		handled <- msg.ID
+		return nil
+	})
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		msgs, err := rdb.XRange(ctx, "events:dlq", "-", "+").Result()
+		if err != nil {
+			t.Fatalf("XRange failed: %v", err)
+		}
+		if len(msgs) == 2 {
+			if msgs[0].Values[stream.FieldDeadLetterID] != first || msgs[1].Values[stream.FieldDeadLetterID] != last {
+				t.Errorf("Unexpected dead letters: %+v", msgs)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 dead letters, got %+v", msgs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case id := <-handled:
+		t.Errorf("Expected no message to be handled, got %s", id)
+	default:
+	}
+
+	// Stop may be called again by the cleanup
+	if err := consumer.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Package stream consumes Redis Streams through consumer groups on the
+// client of a cache.Cache.
+package stream
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	// traceFieldPrefix prefixes the message fields carrying the trace context.
+	traceFieldPrefix = "_otel_"
+
+	// Fields added to messages moved to the dead-letter stream.
+	FieldDeadLetterStream     = "_dlq_stream"
+	FieldDeadLetterID         = "_dlq_id"
+	FieldDeadLetterDeliveries = "_dlq_deliveries"
+)
+
+// Message is a stream entry delivered to a Handler.
+type Message struct {
+	Stream string
+	ID     string
+	Values map[string]any
+	// Deliveries is how many times the message has been delivered, including this one.
+	Deliveries int64
+}
+
+// Publish appends values to stream along with the trace context of ctx, so
+// that consumers continue the trace.
+func Publish(ctx context.Context, client redis.UniversalClient, stream string, values map[string]any) (string, error) {
+	fields := make(map[string]any, len(values)+2)
+	for k, v := range values {
+		fields[k] = v
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	for k, v := range carrier {
+		fields[traceFieldPrefix+k] = v
+	}
+	return client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: fields}).Result()
+}
+
+// extractTrace returns ctx carrying the trace context published with values,
+// and values without the trace fields.
+func extractTrace(ctx context.Context, values map[string]any) (context.Context, map[string]any) {
+	carrier := propagation.MapCarrier{}
+	clean := make(map[string]any, len(values))
+	for k, v := range values {
+		if key, ok := strings.CutPrefix(k, traceFieldPrefix); ok {
+			if s, ok := v.(string); ok {
+				carrier[key] = s
+			}
+			continue
+		}
+		clean[k] = v
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier), clean
+}
@@ -0,0 +1,502 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/stumble/dcache"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrNotFound is returned by Typed when a key has no value: either no loader
+// is configured, or the loader reported ErrNotFound (possibly served from the
+// negative cache).
+var ErrNotFound = errors.New("cache: not found")
+
+var (
+	errNotInitialized = errors.New("cache not initialized")
+	errBatchMiss      = errors.New("cache: batch miss")
+)
+
+// Loader reads the value of key from the underlying data source. Return
+// ErrNotFound (or an error wrapping it) when the value does not exist.
+type Loader[T any] func(ctx context.Context, key string) (T, error)
+
+// BatchLoader reads the values of keys from the underlying data source.
+// Keys missing from the returned map are treated as not found.
+type BatchLoader[T any] func(ctx context.Context, keys []string) (map[string]T, error)
+
+// Values are stored in dcache with a one byte envelope so that negative
+// results can be cached next to regular ones regardless of the codec.
+// envelopeFreshValue additionally carries the soft expiration and the load
+// duration of the value, followed by the encoded value.
+const (
+	envelopeNotFound   byte = 0x0
+	envelopeValue      byte = 0x1
+	envelopeFreshValue byte = 0x2
+
+	freshHeaderLen = 1 + 8 + 4
+)
+
+const (
+	defaultTypedTTL         = 5 * time.Minute
+	defaultBatchConcurrency = 16
+	defaultRefreshTimeout   = 10 * time.Second
+	defaultLoadTimeout      = 10 * time.Second
+)
+
+type typedOptions struct {
+	codec            Codec
+	ttl              time.Duration
+	jitter           float64
+	negativeTTL      time.Duration
+	batchConcurrency int
+	staleTTL         time.Duration
+	earlyBeta        float64
+	refreshTimeout   time.Duration
+	loadTimeout      time.Duration
+}
+
+type TypedOption func(*typedOptions)
+
+// WithCodec sets the codec used to serialize values, JSONCodec by default.
+func WithCodec(codec Codec) TypedOption {
+	return func(o *typedOptions) {
+		o.codec = codec
+	}
+}
+
+// WithTTL sets the expiration of values written by Set and by the loaders.
+func WithTTL(ttl time.Duration) TypedOption {
+	return func(o *typedOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithTTLJitter adds a random extra expiration of up to ratio*ttl to every
+// write, so that keys filled together do not expire together.
+func WithTTLJitter(ratio float64) TypedOption {
+	return func(o *typedOptions) {
+		o.jitter = ratio
+	}
+}
+
+// WithNegativeTTL caches ErrNotFound results of the loaders for ttl.
+// Negative caching is disabled by default.
+func WithNegativeTTL(ttl time.Duration) TypedOption {
+	return func(o *typedOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithBatchConcurrency limits the number of concurrent cache lookups of GetMany and SetMany.
+func WithBatchConcurrency(n int) TypedOption {
+	return func(o *typedOptions) {
+		o.batchConcurrency = n
+	}
+}
+
+// WithStaleWhileRevalidate keeps values for staleTTL after their TTL. Within
+// that window the stale value is served while a single background refresh
+// runs across all replicas. Requires a loader.
+func WithStaleWhileRevalidate(staleTTL time.Duration) TypedOption {
+	return func(o *typedOptions) {
+		o.staleTTL = staleTTL
+	}
+}
+
+// WithEarlyExpiration enables probabilistic early expiration: a fresh value
+// is refreshed in the background before its TTL with a probability growing
+// as the expiration approaches, scaled by beta and by how long the value
+// took to load. 1.0 is a sensible beta; larger values refresh earlier.
+// Requires a loader.
+func WithEarlyExpiration(beta float64) TypedOption {
+	return func(o *typedOptions) {
+		o.earlyBeta = beta
+	}
+}
+
+// WithLoadTimeout bounds a call to the loader, 10s by default. Concurrent
+// misses of a key share one call, which is therefore not cancelled with the
+// ctx of the Get that started it.
+func WithLoadTimeout(timeout time.Duration) TypedOption {
+	return func(o *typedOptions) {
+		o.loadTimeout = timeout
+	}
+}
+
+// WithRefreshTimeout bounds a background refresh, 10s by default.
+func WithRefreshTimeout(timeout time.Duration) TypedOption {
+	return func(o *typedOptions) {
+		o.refreshTimeout = timeout
+	}
+}
+
+// Typed is a typed read-through layer over the in-memory and Redis tiers of
+// Cache.GetDCache. Keys are namespaced as "<namespace>:<key>", and dcache
+// prefixes them with the namespace of the Cache.
+//
+// Concurrent misses of a key are coalesced by dcache into a single loader
+// call per process, see WithLoadTimeout. See WithStaleWhileRevalidate and WithEarlyExpiration to avoid
+// stampedes when hot keys expire.
+type Typed[T any] struct {
+	cache       *Cache
+	namespace   string
+	loader      Loader[T]
+	batchLoader BatchLoader[T]
+	opts        typedOptions
+	refreshing  sync.Map
+}
+
+// entry is a decoded cached value.
+type entry[T any] struct {
+	value        T
+	softExpireAt time.Time // zero if written without freshness information
+	delta        time.Duration
+}
+
+// NewTyped creates a typed view over c. loader may be nil, in which case Get
+// returns ErrNotFound on a miss. c does not need to be initialized yet.
+func NewTyped[T any](c *Cache, namespace string, loader Loader[T], opts ...TypedOption) *Typed[T] {
+	if c == nil {
+		panic("cache cannot be nil")
+	}
+	if namespace == "" {
+		panic("namespace cannot be empty")
+	}
+	t := &Typed[T]{
+		cache:     c,
+		namespace: namespace,
+		loader:    loader,
+		opts: typedOptions{
+			codec:            JSONCodec,
+			ttl:              defaultTypedTTL,
+			batchConcurrency: defaultBatchConcurrency,
+			refreshTimeout:   defaultRefreshTimeout,
+			loadTimeout:      defaultLoadTimeout,
+		},
+	}
+	for _, opt := range opts {
+		opt(&t.opts)
+	}
+	return t
+}
+
+// SetBatchLoader makes GetMany load all missed keys with a single call to bl
+// instead of calling the loader once per key.
+func (t *Typed[T]) SetBatchLoader(bl BatchLoader[T]) *Typed[T] {
+	t.batchLoader = bl
+	return t
+}
+
+// Key returns the namespaced dcache key of key.
+func (t *Typed[T]) Key(key string) string {
+	return t.namespace + ":" + key
+}
+
+// Get returns the value of key, calling the loader on a miss.
+func (t *Typed[T]) Get(ctx context.Context, key string) (T, error) {
+	e, err := t.get(ctx, key, func() (any, time.Duration, error) {
+		return t.load(ctx, key)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return e.value, nil
+}
+
+// Set writes v to every tier and evicts key from the in-memory tier of the
+// other replicas.
+func (t *Typed[T]) Set(ctx context.Context, key string, v T) error {
+	if err := t.set(ctx, key, v); err != nil {
+		return err
+	}
+	return t.cache.evictReplicas(ctx, t.Key(key))
+}
+
+func (t *Typed[T]) set(ctx context.Context, key string, v T) error {
+	dc, err := t.dCache()
+	if err != nil {
+		return err
+	}
+	b, ttl, err := t.encode(v, 0)
+	if err != nil {
+		return err
+	}
+	return dc.Set(ctx, t.Key(key), b, ttl)
+}
+
+// Delete invalidates key in Redis and in the in-memory tier of every replica.
+func (t *Typed[T]) Delete(ctx context.Context, key string) error {
+	return t.cache.Invalidate(ctx, t.Key(key))
+}
+
+// Tag associates keys with tag, see Cache.Tag. Tags expire with the TTL and
+// stale window of the values.
+func (t *Typed[T]) Tag(ctx context.Context, tag string, keys ...string) error {
+	dcKeys := make([]string, len(keys))
+	for i, key := range keys {
+		dcKeys[i] = t.Key(key)
+	}
+	ttl := t.opts.ttl + t.opts.staleTTL
+	if t.opts.jitter > 0 {
+		ttl += time.Duration(t.opts.jitter * float64(t.opts.ttl))
+	}
+	return t.cache.Tag(ctx, tag, ttl, dcKeys...)
+}
+
+// GetMany returns the values of keys. Keys without a value are omitted from
+// the result. Misses are filled by the batch loader if set, otherwise by the
+// loader.
+func (t *Typed[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	var (
+		mu      sync.Mutex
+		result  = make(map[string]T, len(keys))
+		missing []string
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(t.opts.batchConcurrency)
+	for _, key := range keys {
+		g.Go(func() error {
+			read := func() (any, time.Duration, error) {
+				return t.load(gctx, key)
+			}
+			if t.batchLoader != nil {
+				read = func() (any, time.Duration, error) {
+					return nil, 0, errBatchMiss
+				}
+			}
+			e, err := t.get(gctx, key, read)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				result[key] = e.value
+			case errors.Is(err, errBatchMiss):
+				missing = append(missing, key)
+			case !errors.Is(err, ErrNotFound):
+				return err
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	loaded, err := t.batchLoader(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.SetMany(ctx, loaded); err != nil {
+		log.Ctx(ctx).Err(err).Msgf("failed to backfill %d keys of %s", len(loaded), t.namespace)
+	}
+	for _, key := range missing {
+		if v, ok := loaded[key]; ok {
+			result[key] = v
+		} else if t.opts.negativeTTL > 0 {
+			t.setNotFound(ctx, key)
+		}
+	}
+	return result, nil
+}
+
+// SetMany writes every entry of values to every tier and evicts the written
+// keys from the in-memory tier of the other replicas.
+func (t *Typed[T]) SetMany(ctx context.Context, values map[string]T) error {
+	var (
+		mu      sync.Mutex
+		written = make([]string, 0, len(values))
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(t.opts.batchConcurrency)
+	for key, v := range values {
+		g.Go(func() error {
+			if err := t.set(gctx, key, v); err != nil {
+				return err
+			}
+			mu.Lock()
+			written = append(written, t.Key(key))
+			mu.Unlock()
+			return nil
+		})
+	}
+	err := g.Wait()
+	// the keys written before a failure are evicted as well
+	if pubErr := t.cache.evictReplicas(ctx, written...); err == nil {
+		err = pubErr
+	}
+	return err
+}
+
+// get reads key through dcache, calling read on a miss, and schedules a
+// background refresh if the value is stale or expires early.
+func (t *Typed[T]) get(ctx context.Context, key string, read dcache.ReadWithTtlFunc) (e entry[T], err error) {
+	result := typedResultHit
+	defer func() {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			result = typedResultNotFound
+		case errors.Is(err, errBatchMiss):
+			result = typedResultMiss
+		case err != nil:
+			result = typedResultError
+		}
+		typedRequests.WithLabelValues(t.cache.appName, t.cache.name, t.namespace, string(result)).Inc()
+	}()
+
+	dc, err := t.dCache()
+	if err != nil {
+		return e, err
+	}
+	readCalled := false
+	countedRead := func() (any, time.Duration, error) {
+		readCalled = true
+		result = typedResultMiss
+		return read()
+	}
+	var raw []byte
+	err = dc.GetWithTtl(ctx, t.Key(key), &raw, countedRead, false, false)
+	if err != nil && !readCalled && ctx.Err() == nil && t.cache.redisConfig.AllowUnavailable {
+		// Redis failed before the loader was reached, read through without the cache
+		log.Ctx(ctx).Warn().Err(err).Msgf("cache unavailable, loading %s directly", t.Key(key))
+		err = dc.GetWithTtl(ctx, t.Key(key), &raw, countedRead, true, true)
+	}
+	if err != nil {
+		return e, err
+	}
+	e, err = t.decode(raw)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		// the cached bytes cannot be decoded (e.g. the type changed), bypass and overwrite them
+		log.Ctx(ctx).Err(err).Msgf("failed to decode cached value of %s, reloading", t.Key(key))
+		if err = dc.GetWithTtl(ctx, t.Key(key), &raw, countedRead, true, false); err != nil {
+			return e, err
+		}
+		e, err = t.decode(raw)
+	}
+	if err != nil || result == typedResultMiss || e.softExpireAt.IsZero() || !t.revalidates() {
+		return e, err
+	}
+
+	now := t.cache.timeNow()
+	if !now.Before(e.softExpireAt) {
+		result = typedResultStale
+		t.refreshAsync(ctx, key)
+	} else if t.expiresEarly(e, now) {
+		t.refreshAsync(ctx, key)
+	}
+	return e, nil
+}
+
+func (t *Typed[T]) revalidates() bool {
+	return t.loader != nil && (t.opts.staleTTL > 0 || t.opts.earlyBeta > 0)
+}
+
+// expiresEarly implements probabilistic early expiration (XFetch): the value
+// is considered expired when now - delta*beta*ln(rand) passes its soft expiration.
+func (t *Typed[T]) expiresEarly(e entry[T], now time.Time) bool {
+	if t.opts.earlyBeta <= 0 || e.delta <= 0 {
+		return false
+	}
+	// nolint: gosec
+	gap := -float64(e.delta) * t.opts.earlyBeta * math.Log(rand.Float64())
+	return !now.Add(time.Duration(gap)).Before(e.softExpireAt)
+}
+
+func (t *Typed[T]) load(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	if t.loader == nil {
+		return nil, 0, ErrNotFound
+	}
+	// the callers coalesced by dcache share this call, so the first one
+	// being cancelled must not fail the others.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), t.opts.loadTimeout)
+	defer cancel()
+	startedAt := time.Now()
+	v, err := t.loader(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) && t.opts.negativeTTL > 0 {
+			return []byte{envelopeNotFound}, t.opts.negativeTTL, nil
+		}
+		return nil, 0, err
+	}
+	return t.encode(v, time.Since(startedAt))
+}
+
+func (t *Typed[T]) setNotFound(ctx context.Context, key string) {
+	dc, err := t.dCache()
+	if err != nil {
+		return
+	}
+	if err = dc.Set(ctx, t.Key(key), []byte{envelopeNotFound}, t.opts.negativeTTL); err != nil {
+		log.Ctx(ctx).Err(err).Msgf("failed to set negative cache of %s", t.Key(key))
+	}
+}
+
+// encode wraps v into an envelope and returns it with the TTL to store it for,
+// which includes the stale window.
+func (t *Typed[T]) encode(v T, delta time.Duration) ([]byte, time.Duration, error) {
+	b, err := t.opts.codec.Marshal(v)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to marshal with %s codec", t.opts.codec.Name())
+	}
+	ttl := t.expiration()
+	out := make([]byte, freshHeaderLen, freshHeaderLen+len(b))
+	out[0] = envelopeFreshValue
+	// nolint: gosec
+	binary.BigEndian.PutUint64(out[1:9], uint64(t.cache.timeNow().Add(ttl).UnixMilli()))
+	binary.BigEndian.PutUint32(out[9:13], uint32(min(delta.Milliseconds(), math.MaxUint32)))
+	return append(out, b...), ttl + t.opts.staleTTL, nil
+}
+
+func (t *Typed[T]) decode(raw []byte) (entry[T], error) {
+	var e entry[T]
+	if len(raw) == 0 {
+		return e, errors.New("cache: empty value")
+	}
+	payload := raw[1:]
+	switch raw[0] {
+	case envelopeNotFound:
+		return e, ErrNotFound
+	case envelopeValue:
+	case envelopeFreshValue:
+		if len(raw) < freshHeaderLen {
+			return e, errors.New("cache: truncated value envelope")
+		}
+		// nolint: gosec
+		e.softExpireAt = time.UnixMilli(int64(binary.BigEndian.Uint64(raw[1:9])))
+		e.delta = time.Duration(binary.BigEndian.Uint32(raw[9:13])) * time.Millisecond
+		payload = raw[freshHeaderLen:]
+	default:
+		return e, errors.Errorf("cache: unknown value envelope %#x", raw[0])
+	}
+	if err := t.opts.codec.Unmarshal(payload, &e.value); err != nil {
+		return e, errors.Wrapf(err, "failed to unmarshal with %s codec", t.opts.codec.Name())
+	}
+	return e, nil
+}
+
+func (t *Typed[T]) expiration() time.Duration {
+	if t.opts.jitter <= 0 {
+		return t.opts.ttl
+	}
+	// nolint: gosec
+	return t.opts.ttl + time.Duration(rand.Float64()*t.opts.jitter*float64(t.opts.ttl))
+}
+
+func (t *Typed[T]) dCache() (*dcache.DCache, error) {
+	dc := t.cache.GetDCache()
+	if dc == nil {
+		return nil, errNotInitialized
+	}
+	return dc, nil
+}
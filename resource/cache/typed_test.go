@@ -0,0 +1,421 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ggsrc/gglib/resource/cache"
+	"github.com/ggsrc/gglib/resource/cache/cachetest"
+)
+
+type user struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestTyped_GetLoadsOnceAndSetOverrides(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	users := cache.NewTyped(fake.Cache, "user", func(ctx context.Context, key string) (user, error) {
+		loads.Add(1)
+		return user{ID: key, Name: "loaded"}, nil
+	})
+
+	for range 3 {
+		u, err := users.Get(ctx, "1")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if u.Name != "loaded" {
+			t.Errorf("Expected loaded user, got %+v", u)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("Expected loader to be called once, got %d", n)
+	}
+	if !fake.Has(users.Key("1")) {
+		t.Errorf("Expected %s to be stored in redis, keys: %v", users.Key("1"), fake.Keys())
+	}
+
+	if err := users.Set(ctx, "1", user{ID: "1", Name: "set"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if u, _ := users.Get(ctx, "1"); u.Name != "set" {
+		t.Errorf("Expected set user, got %+v", u)
+	}
+
+	if err := users.Delete(ctx, "1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if u, _ := users.Get(ctx, "1"); u.Name != "loaded" || loads.Load() != 2 {
+		t.Errorf("Expected user to be reloaded after Delete, got %+v", u)
+	}
+}
+
+func TestTyped_CodecsRoundTripThroughRedis(t *testing.T) {
+	for _, codec := range []cache.Codec{cache.JSONCodec, cache.MsgpackCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			writer := cachetest.New(t)
+			// another replica, reading from Redis instead of its memory.
+			reader := cachetest.NewWithServer(t, writer.Redis)
+			ctx := context.Background()
+
+			if err := cache.NewTyped[user](writer.Cache, "user", nil, cache.WithCodec(codec)).
+				Set(ctx, "1", user{ID: "1", Name: "alice"}); err != nil {
+				t.Fatalf("Set failed: %v", err)
+			}
+			u, err := cache.NewTyped[user](reader.Cache, "user", nil, cache.WithCodec(codec)).Get(ctx, "1")
+			if err != nil || u != (user{ID: "1", Name: "alice"}) {
+				t.Errorf("Expected alice to be decoded, got %+v, %v", u, err)
+			}
+		})
+	}
+}
+
+func TestTyped_TTLWithJitter(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	users := cache.NewTyped[user](fake.Cache, "user", nil, cache.WithTTL(time.Minute), cache.WithTTLJitter(0.5))
+	for _, key := range []string{"1", "2", "3"} {
+		if err := users.Set(ctx, key, user{ID: key}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if ttl := fake.TTL(users.Key(key)); ttl < time.Minute || ttl > 90*time.Second {
+			t.Errorf("Expected a TTL between 1m and 1m30s, got %s", ttl)
+		}
+	}
+
+	fake.Advance(91 * time.Second)
+	if _, err := users.Get(ctx, "1"); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("Expected the value to expire, got %v", err)
+	}
+}
+
+func TestTyped_LoaderErrorsAreNotCached(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	users := cache.NewTyped(fake.Cache, "user", func(ctx context.Context, key string) (user, error) {
+		if loads.Add(1) == 1 {
+			return user{}, errors.New("database unavailable")
+		}
+		return user{ID: key}, nil
+	})
+
+	if _, err := users.Get(ctx, "1"); err == nil {
+		t.Fatal("Expected the loader error")
+	}
+	if u, err := users.Get(ctx, "1"); err != nil || u.ID != "1" {
+		t.Errorf("Expected the value to be loaded again, got %+v, %v", u, err)
+	}
+}
+
+func TestTyped_ReloadsUndecodableValues(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	if err := cache.NewTyped[user](fake.Cache, "profile", nil).Set(ctx, "1", user{ID: "1"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// the type stored under the namespace changed.
+	counts := cache.NewTyped(fake.Cache, "profile", func(ctx context.Context, key string) (int, error) {
+		return 42, nil
+	})
+	if n, err := counts.Get(ctx, "1"); err != nil || n != 42 {
+		t.Fatalf("Expected the value to be reloaded, got %d, %v", n, err)
+	}
+	if n, err := cache.NewTyped[int](fake.Cache, "profile", nil).Get(ctx, "1"); err != nil || n != 42 {
+		t.Errorf("Expected the reloaded value to be stored, got %d, %v", n, err)
+	}
+}
+
+func TestTyped_GetManyWithLoader(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	users := cache.NewTyped(fake.Cache, "user", func(ctx context.Context, key string) (user, error) {
+		loads.Add(1)
+		if key == "missing" {
+			return user{}, cache.ErrNotFound
+		}
+		return user{ID: key}, nil
+	}, cache.WithBatchConcurrency(2))
+
+	for range 2 {
+		res, err := users.GetMany(ctx, []string{"1", "2", "missing"})
+		if err != nil {
+			t.Fatalf("GetMany failed: %v", err)
+		}
+		if len(res) != 2 || res["1"].ID != "1" || res["2"].ID != "2" {
+			t.Errorf("Unexpected GetMany result: %+v", res)
+		}
+	}
+	// without negative caching the missing key is loaded every time.
+	if n := loads.Load(); n != 4 {
+		t.Errorf("Expected 4 loads, got %d", n)
+	}
+}
+
+func TestTyped_NegativeCaching(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	users := cache.NewTyped(fake.Cache, "user", func(ctx context.Context, key string) (user, error) {
+		loads.Add(1)
+		return user{}, cache.ErrNotFound
+	}, cache.WithNegativeTTL(time.Minute))
+
+	for range 2 {
+		if _, err := users.Get(ctx, "missing"); !errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("Expected not found result to be cached, loader called %d times", n)
+	}
+
+	fake.Advance(2 * time.Minute)
+	if _, err := users.Get(ctx, "missing"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Errorf("Expected negative entry to expire, loader called %d times", n)
+	}
+}
+
+func TestTyped_StaleWhileRevalidate(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var version atomic.Int32
+	version.Store(1)
+	users := cache.NewTyped(fake.Cache, "user", func(ctx context.Context, key string) (user, error) {
+		if version.Load() == 1 {
+			return user{ID: key, Name: "v1"}, nil
+		}
+		return user{ID: key, Name: "v2"}, nil
+	}, cache.WithTTL(time.Minute), cache.WithStaleWhileRevalidate(time.Minute))
+
+	if u, err := users.Get(ctx, "1"); err != nil || u.Name != "v1" {
+		t.Fatalf("Expected v1, got %+v, %v", u, err)
+	}
+
+	version.Store(2)
+	fake.Advance(90 * time.Second)
+	if u, err := users.Get(ctx, "1"); err != nil || u.Name != "v1" {
+		t.Fatalf("Expected stale v1 to be served, got %+v, %v", u, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		u, err := users.Get(ctx, "1")
+		if err == nil && u.Name == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected background refresh to store v2, got %+v, %v", u, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTyped_RefreshKeepsLockTakenOver(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var version atomic.Int32
+	version.Store(1)
+	loading := make(chan struct{}, 1)
+	release := make(chan struct{})
+	users := cache.NewTyped(fake.Cache, "user", func(ctx context.Context, key string) (user, error) {
+		if version.Load() == 1 {
+			return user{ID: key, Name: "v1"}, nil
+		}
+		loading <- struct{}{}
+		<-release
+		return user{ID: key, Name: "v2"}, nil
+	}, cache.WithTTL(time.Minute), cache.WithStaleWhileRevalidate(time.Minute))
+
+	if _, err := users.Get(ctx, "1"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	version.Store(2)
+	fake.Advance(90 * time.Second)
+	if _, err := users.Get(ctx, "1"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	<-loading
+
+	// the refresh outlived its lock, which another replica took.
+	lockKey := fake.Namespace() + ":" + users.Key("1") + ":refresh"
+	if !fake.Redis.Exists(lockKey) {
+		t.Fatalf("Expected the refresh lock to be held, keys: %v", fake.Keys())
+	}
+	fake.Redis.Set(lockKey, "other-replica")
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		u, err := users.Get(ctx, "1")
+		if err == nil && u.Name == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected background refresh to store v2, got %+v, %v", u, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, err := fake.Redis.Get(lockKey); err != nil || v != "other-replica" {
+		t.Errorf("Expected the lock of the other replica to be kept, got %q, %v", v, err)
+	}
+}
+
+func TestTyped_CoalescedGetsOutliveTheFirstCaller(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	users := cache.NewTyped(fake.Cache, "user", func(ctx context.Context, key string) (user, error) {
+		if loads.Add(1) == 1 {
+			close(loading)
+		}
+		<-release
+		if err := ctx.Err(); err != nil {
+			return user{}, err
+		}
+		return user{ID: key, Name: "loaded"}, nil
+	})
+
+	firstCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		_, err := users.Get(firstCtx, "1")
+		first <- err
+	}()
+	<-loading
+	second := make(chan error, 1)
+	go func() {
+		u, err := users.Get(ctx, "1")
+		if err == nil && u.Name != "loaded" {
+			err = errors.New("unexpected user " + u.Name)
+		}
+		second <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(release)
+
+	if err := <-second; err != nil {
+		t.Errorf("Expected the second Get to succeed, got %v", err)
+	}
+	<-first
+	if n := loads.Load(); n != 1 {
+		t.Errorf("Expected one shared load, got %d", n)
+	}
+}
+
+func TestTyped_GetManyWithBatchLoader(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	var batches atomic.Int32
+	users := cache.NewTyped[user](fake.Cache, "user", nil).
+		SetBatchLoader(func(ctx context.Context, keys []string) (map[string]user, error) {
+			batches.Add(1)
+			res := map[string]user{}
+			for _, key := range keys {
+				if key != "missing" {
+					res[key] = user{ID: key}
+				}
+			}
+			return res, nil
+		})
+	if err := users.Set(ctx, "1", user{ID: "1", Name: "cached"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	res, err := users.GetMany(ctx, []string{"1", "2", "3", "missing"})
+	if err != nil {
+		t.Fatalf("GetMany failed: %v", err)
+	}
+	if len(res) != 3 || res["1"].Name != "cached" || res["2"].ID != "2" {
+		t.Errorf("Unexpected GetMany result: %+v", res)
+	}
+	if n := batches.Load(); n != 1 {
+		t.Errorf("Expected one batch load, got %d", n)
+	}
+	if !fake.Has(users.Key("3")) {
+		t.Error("Expected batch loaded values to be backfilled")
+	}
+}
+
+func TestCache_InvalidateTags(t *testing.T) {
+	fake := cachetest.New(t)
+	ctx := context.Background()
+
+	users := cache.NewTyped[user](fake.Cache, "user", nil)
+	for _, key := range []string{"1", "2"} {
+		if err := users.Set(ctx, key, user{ID: key}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if err := users.Tag(ctx, "team:a", "1", "2"); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	if err := fake.InvalidateTags(ctx, "team:a"); err != nil {
+		t.Fatalf("InvalidateTags failed: %v", err)
+	}
+	for _, key := range []string{"1", "2"} {
+		if _, err := users.Get(ctx, key); !errors.Is(err, cache.ErrNotFound) {
+			t.Errorf("Expected %s to be invalidated, got %v", key, err)
+		}
+	}
+}
+
+func TestTyped_SetEvictsOtherReplicas(t *testing.T) {
+	a := cachetest.New(t)
+	b := cachetest.NewWithServer(t, a.Redis)
+	ctx := context.Background()
+
+	usersA := cache.NewTyped[user](a.Cache, "user", nil)
+	usersB := cache.NewTyped[user](b.Cache, "user", nil)
+	if err := usersA.Set(ctx, "1", user{ID: "1", Name: "old"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// fill the in-memory tier of b
+	if u, err := usersB.Get(ctx, "1"); err != nil || u.Name != "old" {
+		t.Fatalf("Expected old user on b, got %+v, %v", u, err)
+	}
+
+	if err := usersA.Set(ctx, "1", user{ID: "1", Name: "new"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := usersA.SetMany(ctx, map[string]user{"2": {ID: "2", Name: "new"}}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	for _, key := range []string{"1", "2"} {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			u, err := usersB.Get(ctx, key)
+			if err == nil && u.Name == "new" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected b to read the new value of %s, got %+v, %v", key, u, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
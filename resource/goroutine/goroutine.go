@@ -2,11 +2,20 @@ package goroutine
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/ggsrc/gglib/zerolog/log"
 )
 
+const name = "github.com/ggsrc/gglib/resource/goroutine"
+
+var tracer = otel.Tracer(name)
+
 type GoroutineManager struct {
 	ctx    context.Context
 	cancel func()
@@ -60,18 +69,58 @@ func (g *GoroutineManager) Run(name string, f func(ctx context.Context) error) {
 	if g.ctx == nil {
 		log.Panic().Msgf("GoroutineManager run called before init")
 	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.run(g.ctx, name, f)
+	}()
+}
+
+// RunWithContext is like Run, but the worker context carries the values of
+// parentCtx (trace context, mctx.AppContext, gRPC metadata) instead of the
+// manager's bare root context. Cancellation of parentCtx is detached, so the
+// task outlives the request that spawned it; the worker is cancelled only
+// when the manager stops. The work runs in a new span linked to the caller's.
+func (g *GoroutineManager) RunWithContext(parentCtx context.Context, name string, f func(ctx context.Context) error) {
+	if g.ctx == nil {
+		log.Panic().Msgf("GoroutineManager run called before init")
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parentCtx))
+	stop := context.AfterFunc(g.ctx, cancel)
+
 	g.wg.Add(1)
 	go func() {
 		defer func() {
-			if r := recover(); r != nil {
-				log.Ctx(g.ctx).Error().Interface("panic", r).Msgf("GoroutineManager [%s] panicked", name)
-			}
+			stop()
+			cancel()
 			g.wg.Done()
 		}()
 
-		err := f(g.ctx)
-		if err != nil {
-			log.Err(err).Msgf("goroutine [%s] error", name)
+		ctx, span := tracer.Start(ctx, "goroutine."+name,
+			trace.WithNewRoot(),
+			trace.WithLinks(trace.LinkFromContext(parentCtx)),
+			trace.WithSpanKind(trace.SpanKindInternal),
+		)
+		defer span.End()
+
+		if err := g.run(ctx, name, f); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
 	}()
 }
+
+func (g *GoroutineManager) run(ctx context.Context, name string, f func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Ctx(ctx).Error().Interface("panic", r).Msgf("GoroutineManager [%s] panicked", name)
+			err = fmt.Errorf("[panic] %v", r)
+		}
+	}()
+
+	err = f(ctx)
+	if err != nil {
+		log.Err(err).Ctx(ctx).Msgf("goroutine [%s] error", name)
+	}
+	return err
+}
@@ -1,10 +1,17 @@
 package goroutine
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
 )
 
 func TestGoroutineManager_GracefulShutdown_WithWork(t *testing.T) {
@@ -49,3 +56,87 @@ func TestGoroutineManager_GracefulShutdown_WithWork(t *testing.T) {
 		t.Errorf("Expected %d goroutines to complete work, but got %d", numGoroutines, count)
 	}
 }
+
+func TestGoroutineManager_RunWithContext_DetachesCancellation(t *testing.T) {
+	type ctxKey struct{}
+
+	gm := NewGoroutineManager()
+	ctx := context.Background()
+
+	if err := gm.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	parentCtx, parentCancel := context.WithCancel(context.WithValue(ctx, ctxKey{}, "request"))
+	started := make(chan struct{})
+	var gotValue atomic.Value
+	var cancelledByStop atomic.Bool
+
+	gm.RunWithContext(parentCtx, "worker", func(ctx context.Context) error {
+		gotValue.Store(ctx.Value(ctxKey{}))
+		close(started)
+		<-ctx.Done()
+		cancelledByStop.Store(true)
+		return nil
+	})
+
+	<-started
+	parentCancel()
+
+	// Cancelling the parent must not cancel the managed goroutine
+	time.Sleep(20 * time.Millisecond)
+	if cancelledByStop.Load() {
+		t.Fatal("worker was cancelled by parent context")
+	}
+
+	if err := gm.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	if v := gotValue.Load(); v != "request" {
+		t.Errorf("Expected parent value to be propagated, got %v", v)
+	}
+	if !cancelledByStop.Load() {
+		t.Error("Expected worker to be cancelled by Stop")
+	}
+}
+
+func TestGoroutineManager_Run_LogsErrorWithoutContextLogger(t *testing.T) {
+	var buf syncBuffer
+	prev := zlog.Logger
+	zlog.Logger = zerolog.New(&buf)
+	defer func() { zlog.Logger = prev }()
+
+	gm := NewGoroutineManager()
+	ctx := context.Background()
+	if err := gm.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	gm.Run("failing", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	if err := gm.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	if out := buf.String(); !strings.Contains(out, "goroutine [failing] error") || !strings.Contains(out, "boom") {
+		t.Errorf("Expected the error to be logged, got %q", out)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
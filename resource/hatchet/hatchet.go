@@ -3,9 +3,26 @@ package hatchet
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/hatchet-dev/hatchet/pkg/client/rest"
 	hatchetcli "github.com/hatchet-dev/hatchet/pkg/v1"
 	hatchetworker "github.com/hatchet-dev/hatchet/pkg/v1/worker"
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+
+	"github.com/ggsrc/gglib/interceptor/grpc/recovery"
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+const (
+	defaultStartAttempts = 5
+	defaultStartBackoff  = time.Second
+	maxStartBackoff      = 30 * time.Second
+	// inflightPollInterval is how often Stop checks for in-flight tasks.
+	inflightPollInterval = 50 * time.Millisecond
 )
 
 type Hatchet struct {
@@ -15,13 +32,31 @@ type Hatchet struct {
 	hatchetCli            hatchetcli.HatchetClient
 	hatchetWorker         hatchetworker.Worker
 	workerCleanupFunction func() error
+
+	startAttempts int
+	startBackoff  time.Duration
+	panicHandler  recovery.PanicHandler
+
+	mu        sync.Mutex
+	started   bool
+	workflows int
+	stopped   bool
+	inflight  atomic.Int64
 }
 
-func NewHatchet(clientOpt []hatchetcli.Config, workerOpt hatchetworker.WorkerOpts) *Hatchet {
-	return &Hatchet{
-		clientOpts: clientOpt,
-		workerOpt:  workerOpt,
+func NewHatchet(clientOpt []hatchetcli.Config, workerOpt hatchetworker.WorkerOpts, opts ...Option) *Hatchet {
+	h := &Hatchet{
+		clientOpts:    clientOpt,
+		workerOpt:     workerOpt,
+		startAttempts: defaultStartAttempts,
+		startBackoff:  defaultStartBackoff,
+		workflows:     len(workerOpt.Workflows),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	h.startAttempts = max(h.startAttempts, 1)
+	return h
 }
 
 func (h *Hatchet) Init(ctx context.Context) error {
@@ -31,11 +66,18 @@ func (h *Hatchet) Init(ctx context.Context) error {
 	}
 	h.hatchetCli = client
 
-	worker, err := client.Worker(h.workerOpt)
+	// creating the worker registers its workflows with the engine.
+	err = h.retry(ctx, "register worker", func() error {
+		w, err := client.Worker(h.workerOpt)
+		if err != nil {
+			return err
+		}
+		h.hatchetWorker = w
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	h.hatchetWorker = worker
 	h.initialized = true
 	return nil
 }
@@ -44,20 +86,80 @@ func (h *Hatchet) Start(ctx context.Context) error {
 	if !h.initialized {
 		return errors.New("hatchet not initialized")
 	}
-	var err error
-	h.workerCleanupFunction, err = h.hatchetWorker.Start()
+	// the worker panics if it cannot reach the engine once started, so make
+	// sure it is reachable first.
+	err := h.retry(ctx, "connect to engine", func() error {
+		_, err := h.hatchetCli.Workers().List(ctx)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	cleanup, err := h.hatchetWorker.Start()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.workerCleanupFunction = cleanup
+	h.started = true
+	h.mu.Unlock()
 	return nil
 }
 
+// Stop stops listening for new tasks and waits for the in-flight ones
+// wrapped by TrackTask or Instrument until ctx is done.
 func (h *Hatchet) Stop(ctx context.Context) error {
-	return h.workerCleanupFunction()
+	h.mu.Lock()
+	cleanup := h.workerCleanupFunction
+	h.workerCleanupFunction = nil
+	h.stopped = true
+	h.mu.Unlock()
+	if cleanup == nil {
+		return nil
+	}
+	if err := cleanup(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(inflightPollInterval)
+	defer ticker.Stop()
+	for h.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d hatchet tasks still running: %w", h.inflight.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
+// OK fails until the worker is started, after it is stopped, if the engine
+// is unreachable, and if the worker has workflows but no active worker of
+// its name is registered with the engine.
 func (h *Hatchet) OK(ctx context.Context) error {
-	return nil
+	h.mu.Lock()
+	started, stopped, workflows := h.started, h.stopped, h.workflows
+	h.mu.Unlock()
+	switch {
+	case stopped:
+		return errors.New("hatchet worker stopped")
+	case !started:
+		return errors.New("hatchet worker not started")
+	}
+
+	workers, err := h.hatchetCli.Workers().List(ctx)
+	if err != nil {
+		return fmt.Errorf("hatchet engine unreachable: %w", err)
+	}
+	if workflows == 0 || workers.Rows == nil {
+		return nil
+	}
+	for _, w := range *workers.Rows {
+		if w.Name == h.workerOpt.Name && w.Status != nil && *w.Status == rest.ACTIVE {
+			return nil
+		}
+	}
+	return fmt.Errorf("no active hatchet worker %s registered", h.workerOpt.Name)
 }
 
 func (h *Hatchet) Name() string {
@@ -73,5 +175,40 @@ func (h *Hatchet) GetHatchetCli() hatchetcli.HatchetClient {
 }
 
 func (h *Hatchet) GetWorkerCleanupFunction() func() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	return h.workerCleanupFunction
 }
+
+// TrackTask wraps fn so that Stop waits for its runs. A nil h returns fn.
+func TrackTask[I, O any](h *Hatchet, fn TaskFunc[I, O]) TaskFunc[I, O] {
+	if h == nil {
+		return fn
+	}
+	return func(ctx worker.HatchetContext, input I) (*O, error) {
+		h.inflight.Add(1)
+		defer h.inflight.Add(-1)
+		return fn(ctx, input)
+	}
+}
+
+// retry runs fn up to startAttempts times with exponential backoff.
+func (h *Hatchet) retry(ctx context.Context, op string, fn func() error) error {
+	backoff := h.startBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= h.startAttempts {
+			return fmt.Errorf("hatchet failed to %s after %d attempts: %w", op, attempt, err)
+		}
+		log.Ctx(ctx).Warn().Err(err).Msgf("hatchet failed to %s, retrying in %s", op, backoff)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("hatchet failed to %s: %w", op, errors.Join(err, ctx.Err()))
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxStartBackoff)
+	}
+}
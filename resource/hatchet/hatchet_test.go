@@ -0,0 +1,44 @@
+package hatchet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	hatchetworker "github.com/hatchet-dev/hatchet/pkg/v1/worker"
+)
+
+func TestHatchet_StopBeforeStart(t *testing.T) {
+	h := NewHatchet(nil, hatchetworker.WorkerOpts{Name: "test"})
+	if err := h.Stop(context.Background()); err != nil {
+		t.Errorf("Expected Stop to be a no-op, got %v", err)
+	}
+	if err := h.OK(context.Background()); err == nil {
+		t.Error("Expected OK to fail before Start")
+	}
+}
+
+func TestHatchet_Retry(t *testing.T) {
+	h := NewHatchet(nil, hatchetworker.WorkerOpts{}, WithStartRetry(3, time.Millisecond))
+	calls := 0
+	err := h.retry(context.Background(), "test", func() error {
+		calls++
+		return errors.New("unavailable")
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("Expected 3 failed attempts, got %d calls and %v", calls, err)
+	}
+
+	calls = 0
+	err = h.retry(context.Background(), "test", func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Expected success on the second attempt, got %d calls and %v", calls, err)
+	}
+}
@@ -0,0 +1,306 @@
+package hatchetlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hatchet-dev/hatchet/api/v1/server/oas/gen"
+	v0client "github.com/hatchet-dev/hatchet/pkg/client"
+	cloudrest "github.com/hatchet-dev/hatchet/pkg/client/cloud/rest"
+	"github.com/hatchet-dev/hatchet/pkg/client/create"
+	"github.com/hatchet-dev/hatchet/pkg/client/rest"
+	"github.com/hatchet-dev/hatchet/pkg/client/types"
+	hatchetcli "github.com/hatchet-dev/hatchet/pkg/v1"
+	"github.com/hatchet-dev/hatchet/pkg/v1/features"
+	"github.com/hatchet-dev/hatchet/pkg/v1/worker"
+	"github.com/hatchet-dev/hatchet/pkg/v1/workflow"
+	"github.com/rs/zerolog"
+)
+
+// localTenantID is a placeholder, declarations require a tenant UUID.
+const localTenantID = "00000000-0000-0000-0000-000000000000"
+
+// ErrNotSupported is returned by the methods of declarations and of the
+// client of GetHatchetCli that need an engine, such as the Run and
+// RunNoWait methods of declarations or Events().Push.
+var ErrNotSupported = errors.New("hatchetlocal: not supported, runs are triggered with hatchetlocal.Run and RunNoWait")
+
+// client is the client declarations are created with. Everything needing
+// an engine fails with ErrNotSupported: the gRPC clients directly, the REST
+// ones through an HTTP client refusing every request.
+type client struct {
+	v0 *v0Client
+}
+
+var _ hatchetcli.HatchetClient = (*client)(nil)
+
+func newClient() *client {
+	api, err := rest.NewClientWithResponses("http://hatchetlocal", rest.WithHTTPClient(refusingDoer{}))
+	if err != nil {
+		panic(err) // the server URL is valid, options do not fail
+	}
+	return &client{v0: &v0Client{api: api}}
+}
+
+// refusingDoer fails the requests of the REST clients.
+type refusingDoer struct{}
+
+func (refusingDoer) Do(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, ErrNotSupported)
+}
+
+func (c *client) V0() v0client.Client {
+	return c.v0
+}
+
+func (c *client) Workflow(opts create.WorkflowCreateOpts[any]) workflow.WorkflowDeclaration[any, any] {
+	return workflow.NewWorkflowDeclaration[any, any](opts, c.v0)
+}
+
+func (c *client) Worker(opts worker.WorkerOpts) (worker.Worker, error) {
+	return nil, fmt.Errorf("worker %s: %w", opts.Name, ErrNotSupported)
+}
+
+func (c *client) Events() v0client.EventClient {
+	return c.v0.Event()
+}
+
+func (c *client) Metrics() features.MetricsClient {
+	tenantID := localTenantID
+	return features.NewMetricsClient(c.v0.api, &tenantID)
+}
+
+func (c *client) RateLimits() features.RateLimitsClient {
+	tenantID := localTenantID
+	admin := c.v0.Admin()
+	return features.NewRateLimitsClient(c.v0.api, &tenantID, &admin)
+}
+
+func (c *client) Runs() features.RunsClient {
+	tenantID := localTenantID
+	return features.NewRunsClient(c.v0.api, &tenantID, c.v0)
+}
+
+func (c *client) Workers() features.WorkersClient {
+	tenantID := localTenantID
+	return features.NewWorkersClient(c.v0.api, &tenantID)
+}
+
+func (c *client) Workflows() features.WorkflowsClient {
+	tenantID := localTenantID
+	return features.NewWorkflowsClient(c.v0.api, &tenantID)
+}
+
+func (c *client) Crons() features.CronsClient {
+	tenantID := localTenantID
+	return features.NewCronsClient(c.v0.api, &tenantID)
+}
+
+func (c *client) CEL() features.CELClient {
+	tenantID := localTenantID
+	return features.NewCELClient(c.v0.api, &tenantID)
+}
+
+func (c *client) Schedules() features.SchedulesClient {
+	tenantID, namespace := localTenantID, ""
+	return features.NewSchedulesClient(c.v0.api, &tenantID, &namespace)
+}
+
+func (c *client) Filters() features.FiltersClient {
+	tenantID := localTenantID
+	return features.NewFiltersClient(c.v0.api, &tenantID)
+}
+
+type v0Client struct {
+	api *rest.ClientWithResponses
+}
+
+var _ v0client.Client = (*v0Client)(nil)
+
+func (c *v0Client) Admin() v0client.AdminClient {
+	return adminClient{}
+}
+
+func (c *v0Client) Cron() v0client.CronClient {
+	return cronClient{}
+}
+
+func (c *v0Client) Schedule() v0client.ScheduleClient {
+	return scheduleClient{}
+}
+
+func (c *v0Client) Dispatcher() v0client.DispatcherClient {
+	return dispatcherClient{}
+}
+
+func (c *v0Client) Event() v0client.EventClient {
+	return eventClient{}
+}
+
+func (c *v0Client) Subscribe() v0client.SubscribeClient {
+	return subscribeClient{}
+}
+
+func (c *v0Client) API() *rest.ClientWithResponses {
+	return c.api
+}
+
+func (c *v0Client) CloudAPI() *cloudrest.ClientWithResponses {
+	return nil
+}
+
+func (c *v0Client) Logger() *zerolog.Logger {
+	l := zerolog.Nop()
+	return &l
+}
+
+func (c *v0Client) TenantId() string {
+	return localTenantID
+}
+
+func (c *v0Client) Namespace() string {
+	return ""
+}
+
+func (c *v0Client) CloudRegisterID() *string {
+	return nil
+}
+
+func (c *v0Client) RunnableActions() []string {
+	return nil
+}
+
+// adminClient triggers the runs of declarations. PutWorkflowV1, taking a
+// type internal to the Hatchet module, is left to the nil AdminClient: only
+// workers call it, and Worker fails.
+type adminClient struct {
+	v0client.AdminClient
+}
+
+func (adminClient) PutWorkflow(workflow *types.Workflow, opts ...v0client.PutOptFunc) error {
+	return fmt.Errorf("put workflow %s: %w", workflow.Name, ErrNotSupported)
+}
+
+func (adminClient) ScheduleWorkflow(workflowName string, opts ...v0client.ScheduleOptFunc) error {
+	return fmt.Errorf("schedule workflow %s: %w", workflowName, ErrNotSupported)
+}
+
+func (adminClient) RunWorkflow(workflowName string, input any, opts ...v0client.RunOptFunc) (*v0client.Workflow, error) {
+	return nil, fmt.Errorf("run workflow %s: %w", workflowName, ErrNotSupported)
+}
+
+func (adminClient) BulkRunWorkflow(workflows []*v0client.WorkflowRun) ([]string, error) {
+	return nil, fmt.Errorf("bulk run workflows: %w", ErrNotSupported)
+}
+
+func (adminClient) RunChildWorkflow(workflowName string, input any, opts *v0client.ChildWorkflowOpts) (string, error) {
+	return "", fmt.Errorf("run child workflow %s: %w", workflowName, ErrNotSupported)
+}
+
+func (adminClient) RunChildWorkflows(workflows []*v0client.RunChildWorkflowsOpts) ([]string, error) {
+	return nil, fmt.Errorf("run child workflows: %w", ErrNotSupported)
+}
+
+func (adminClient) PutRateLimit(key string, opts *types.RateLimitOpts) error {
+	return fmt.Errorf("put rate limit %s: %w", key, ErrNotSupported)
+}
+
+type cronClient struct{}
+
+func (cronClient) Create(ctx context.Context, workflow string, opts *v0client.CronOpts) (*gen.CronWorkflows, error) {
+	return nil, fmt.Errorf("create cron of workflow %s: %w", workflow, ErrNotSupported)
+}
+
+func (cronClient) Delete(ctx context.Context, id string) error {
+	return fmt.Errorf("delete cron %s: %w", id, ErrNotSupported)
+}
+
+func (cronClient) List(ctx context.Context) (*gen.CronWorkflowsList, error) {
+	return nil, fmt.Errorf("list crons: %w", ErrNotSupported)
+}
+
+type scheduleClient struct{}
+
+func (scheduleClient) Create(ctx context.Context, workflow string, opts *v0client.ScheduleOpts) (*gen.ScheduledWorkflows, error) {
+	return nil, fmt.Errorf("schedule workflow %s: %w", workflow, ErrNotSupported)
+}
+
+func (scheduleClient) Delete(ctx context.Context, id string) error {
+	return fmt.Errorf("delete scheduled run %s: %w", id, ErrNotSupported)
+}
+
+func (scheduleClient) List(ctx context.Context) (*gen.ScheduledWorkflowsList, error) {
+	return nil, fmt.Errorf("list scheduled runs: %w", ErrNotSupported)
+}
+
+// dispatcherClient is only used by workers. RegisterDurableEvent, taking a
+// type internal to the Hatchet module, is left to the nil DispatcherClient.
+type dispatcherClient struct {
+	v0client.DispatcherClient
+}
+
+func (dispatcherClient) GetActionListener(ctx context.Context, req *v0client.GetActionListenerRequest) (v0client.WorkerActionListener, *string, error) {
+	return nil, nil, fmt.Errorf("listen for actions: %w", ErrNotSupported)
+}
+
+func (dispatcherClient) SendStepActionEvent(ctx context.Context, in *v0client.ActionEvent) (*v0client.ActionEventResponse, error) {
+	return nil, fmt.Errorf("send step action event: %w", ErrNotSupported)
+}
+
+func (dispatcherClient) SendGroupKeyActionEvent(ctx context.Context, in *v0client.ActionEvent) (*v0client.ActionEventResponse, error) {
+	return nil, fmt.Errorf("send group key action event: %w", ErrNotSupported)
+}
+
+func (dispatcherClient) ReleaseSlot(ctx context.Context, stepRunId string) error {
+	return fmt.Errorf("release slot: %w", ErrNotSupported)
+}
+
+func (dispatcherClient) RefreshTimeout(ctx context.Context, stepRunId string, incrementTimeoutBy string) error {
+	return fmt.Errorf("refresh timeout: %w", ErrNotSupported)
+}
+
+func (dispatcherClient) UpsertWorkerLabels(ctx context.Context, workerId string, labels map[string]any) error {
+	return fmt.Errorf("upsert worker labels: %w", ErrNotSupported)
+}
+
+type eventClient struct{}
+
+func (eventClient) Push(ctx context.Context, eventKey string, payload any, options ...v0client.PushOpFunc) error {
+	return fmt.Errorf("push event %s: %w", eventKey, ErrNotSupported)
+}
+
+func (eventClient) BulkPush(ctx context.Context, payloads []v0client.EventWithAdditionalMetadata, options ...v0client.BulkPushOpFunc) error {
+	return fmt.Errorf("bulk push events: %w", ErrNotSupported)
+}
+
+func (eventClient) PutLog(ctx context.Context, stepRunId, msg string) error {
+	return fmt.Errorf("put log: %w", ErrNotSupported)
+}
+
+func (eventClient) PutStreamEvent(ctx context.Context, stepRunId string, message []byte, options ...v0client.StreamEventOption) error {
+	return fmt.Errorf("put stream event: %w", ErrNotSupported)
+}
+
+type subscribeClient struct{}
+
+func (subscribeClient) On(ctx context.Context, workflowRunId string, handler v0client.RunHandler) error {
+	return fmt.Errorf("subscribe to run %s: %w", workflowRunId, ErrNotSupported)
+}
+
+func (subscribeClient) Stream(ctx context.Context, workflowRunId string, handler v0client.StreamHandler) error {
+	return fmt.Errorf("stream run %s: %w", workflowRunId, ErrNotSupported)
+}
+
+func (subscribeClient) StreamByAdditionalMetadata(ctx context.Context, key string, value string, handler v0client.StreamHandler) error {
+	return fmt.Errorf("stream runs by metadata %s: %w", key, ErrNotSupported)
+}
+
+func (subscribeClient) SubscribeToWorkflowRunEvents(ctx context.Context) (*v0client.WorkflowRunsListener, error) {
+	return nil, fmt.Errorf("subscribe to run events: %w", ErrNotSupported)
+}
+
+func (subscribeClient) ListenForDurableEvents(ctx context.Context) (*v0client.DurableEventsListener, error) {
+	return nil, fmt.Errorf("listen for durable events: %w", ErrNotSupported)
+}
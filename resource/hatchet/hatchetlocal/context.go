@@ -0,0 +1,133 @@
+package hatchetlocal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/hatchet-dev/hatchet/pkg/client/create"
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+// hatchetContext is the worker.HatchetContext of local tasks. Methods
+// needing an engine, such as SpawnWorkflow, are not implemented and panic.
+type hatchetContext struct {
+	worker.HatchetContext
+
+	ctx        context.Context
+	state      *runState
+	task       string
+	retryCount int
+}
+
+func newContext(ctx context.Context, state *runState, task string, retryCount int) *hatchetContext {
+	return &hatchetContext{
+		ctx:        ctx,
+		state:      state,
+		task:       task,
+		retryCount: retryCount,
+	}
+}
+
+func (c *hatchetContext) Deadline() (time.Time, bool) {
+	return c.ctx.Deadline()
+}
+
+func (c *hatchetContext) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+func (c *hatchetContext) Err() error {
+	return c.ctx.Err()
+}
+
+func (c *hatchetContext) Value(key any) any {
+	return c.ctx.Value(key)
+}
+
+func (c *hatchetContext) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+func (c *hatchetContext) GetContext() context.Context {
+	return c.ctx
+}
+
+func (c *hatchetContext) WorkflowInput(target any) error {
+	return json.Unmarshal(c.state.input, target)
+}
+
+func (c *hatchetContext) StepOutput(step string, target any) error {
+	b, ok := c.state.output(step)
+	if !ok {
+		return fmt.Errorf("output of %s not found", step)
+	}
+	return json.Unmarshal(b, target)
+}
+
+func (c *hatchetContext) ParentOutput(parent create.NamedTask, target any) error {
+	return c.StepOutput(parent.GetName(), target)
+}
+
+func (c *hatchetContext) StepRunErrors() map[string]string {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return maps.Clone(c.state.errors)
+}
+
+func (c *hatchetContext) AdditionalMetadata() map[string]string {
+	return maps.Clone(c.state.metadata)
+}
+
+func (c *hatchetContext) TriggeredByEvent() bool {
+	return false
+}
+
+func (c *hatchetContext) StepName() string {
+	return c.task
+}
+
+func (c *hatchetContext) StepId() string {
+	return c.task
+}
+
+func (c *hatchetContext) StepRunId() string {
+	return fmt.Sprintf("%s/%s/%d", c.state.runID, c.task, c.retryCount)
+}
+
+func (c *hatchetContext) WorkflowRunId() string {
+	return c.state.runID
+}
+
+func (c *hatchetContext) WorkflowId() *string {
+	return &c.state.workflow
+}
+
+func (c *hatchetContext) WorkflowVersionId() *string {
+	return nil
+}
+
+func (c *hatchetContext) RetryCount() int {
+	return c.retryCount
+}
+
+func (c *hatchetContext) Log(message string) {
+	log.Ctx(c.ctx).Info().Str("task", c.task).Str("workflow_run_id", c.state.runID).Msg(message)
+}
+
+func (c *hatchetContext) StreamEvent(message []byte) {}
+
+func (c *hatchetContext) PutStream(message string) {}
+
+func (c *hatchetContext) ReleaseSlot() error {
+	return nil
+}
+
+// RefreshTimeout is a no-op, local timeouts are not extended.
+func (c *hatchetContext) RefreshTimeout(incrementTimeoutBy string) error {
+	return nil
+}
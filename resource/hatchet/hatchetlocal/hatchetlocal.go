@@ -0,0 +1,549 @@
+// Package hatchetlocal runs Hatchet workflows in process, without an
+// engine, for local runs and tests.
+//
+// Runner is a hatchet.Registry, so workflows declared with hatchet.NewTask,
+// hatchet.NewWorkflow and hatchet.AddTask register with it as with the
+// Hatchet resource. They are triggered with Run and RunNoWait of this
+// package instead of the methods of their declaration, which need an
+// engine and return ErrNotSupported:
+//
+//	r := hatchetlocal.NewRunner()
+//	greet, err := hatchet.NewTask(r, create.StandaloneTask{Name: "greet"}, greetFn)
+//	out, err := hatchetlocal.Run(ctx, r, greet, GreetInput{Name: "alice"})
+package hatchetlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hatchetcli "github.com/hatchet-dev/hatchet/pkg/v1"
+	"github.com/hatchet-dev/hatchet/pkg/v1/workflow"
+
+	"github.com/ggsrc/gglib/resource/hatchet"
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+// defaultTimeout is the execution timeout of the engine for tasks without
+// one.
+const defaultTimeout = 60 * time.Second
+
+type Option func(r *Runner)
+
+// WithAsync runs the independent tasks of a run on goroutines, and the runs
+// of RunNoWait in the background. By default everything runs on the calling
+// goroutine, so RunNoWait returns once the run is finished.
+func WithAsync() Option {
+	return func(r *Runner) {
+		r.async = true
+	}
+}
+
+// WithDefaultTimeout sets the execution timeout of the tasks without one,
+// 60s by default.
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return func(r *Runner) {
+		r.defaultTimeout = timeout
+	}
+}
+
+// Runner is a resource running the registered workflows in process. Task
+// timeouts and retries are honored; concurrency, rate limits, conditions,
+// schedules and durable tasks are not supported.
+type Runner struct {
+	async          bool
+	defaultTimeout time.Duration
+
+	mu        sync.RWMutex
+	workflows map[string]*localWorkflow
+	stopped   bool
+	stop      chan struct{} // closed by Stop, interrupting retry backoffs
+	runs      sync.WaitGroup
+	runSeq    atomic.Int64
+	client    *client
+}
+
+type localWorkflow struct {
+	name string
+	// standalone workflows output the output of their single task.
+	standalone bool
+	// levels are the tasks grouped by depth, each depending only on tasks
+	// of the previous levels.
+	levels    [][]*localTask
+	onFailure workflow.WrappedTaskFn
+}
+
+type localTask struct {
+	name          string
+	parents       []string
+	fn            workflow.WrappedTaskFn
+	timeout       time.Duration
+	retries       int
+	backoffFactor float64
+	maxBackoff    time.Duration
+}
+
+var _ hatchet.Registry = (*Runner)(nil)
+
+func NewRunner(opts ...Option) *Runner {
+	r := &Runner{
+		defaultTimeout: defaultTimeout,
+		workflows:      map[string]*localWorkflow{},
+		stop:           make(chan struct{}),
+		client:         newClient(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *Runner) Name() string {
+	return "hatchet_local"
+}
+
+func (r *Runner) Init(ctx context.Context) error {
+	return nil
+}
+
+func (r *Runner) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop refuses new runs and waits for the running ones until ctx is done.
+// Tasks waiting to be retried fail instead.
+func (r *Runner) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.stopped {
+		r.stopped = true
+		close(r.stop)
+	}
+	r.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) OK(ctx context.Context) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.stopped {
+		return errors.New("hatchet local runner stopped")
+	}
+	return nil
+}
+
+// GetHatchetCli returns a client without engine: declarations are run by
+// Run and RunNoWait, their own methods return ErrNotSupported.
+func (r *Runner) GetHatchetCli() hatchetcli.HatchetClient {
+	return r.client
+}
+
+func (r *Runner) RegisterWorkflows(workflows ...workflow.WorkflowBase) error {
+	for _, wf := range workflows {
+		lw, err := r.load(wf)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		if _, ok := r.workflows[lw.name]; ok {
+			r.mu.Unlock()
+			return fmt.Errorf("workflow %s already registered", lw.name)
+		}
+		r.workflows[lw.name] = lw
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// load converts the declaration of wf into its tasks sorted by level.
+func (r *Runner) load(wf workflow.WorkflowBase) (*localWorkflow, error) {
+	dump, fns, durableFns, onFailure := wf.Dump()
+	if len(durableFns) > 0 {
+		return nil, fmt.Errorf("workflow %s: durable tasks are not supported", dump.Name)
+	}
+	actions := make(map[string]workflow.WrappedTaskFn, len(fns))
+	for _, fn := range fns {
+		actions[fn.ActionID] = fn.Fn
+	}
+
+	tasks := make(map[string]*localTask, len(dump.Tasks))
+	for _, t := range dump.Tasks {
+		fn, ok := actions[t.Action]
+		if !ok {
+			return nil, fmt.Errorf("workflow %s: no function for task %s", dump.Name, t.ReadableId)
+		}
+		lt := &localTask{
+			name:    t.ReadableId,
+			parents: t.Parents,
+			fn:      fn,
+			timeout: r.defaultTimeout,
+			retries: int(t.Retries),
+		}
+		if t.Timeout != "" {
+			timeout, err := time.ParseDuration(t.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("workflow %s: invalid timeout of task %s: %w", dump.Name, t.ReadableId, err)
+			}
+			lt.timeout = timeout
+		}
+		if t.BackoffFactor != nil {
+			lt.backoffFactor = float64(*t.BackoffFactor)
+			lt.maxBackoff = 24 * time.Hour
+		}
+		if t.BackoffMaxSeconds != nil {
+			lt.maxBackoff = time.Duration(*t.BackoffMaxSeconds) * time.Second
+		}
+		tasks[lt.name] = lt
+	}
+
+	levels, err := sortLevels(tasks)
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %w", dump.Name, err)
+	}
+	return &localWorkflow{
+		name:       dump.Name,
+		standalone: len(tasks) == 1 && tasks[dump.Name] != nil,
+		levels:     levels,
+		onFailure:  onFailure,
+	}, nil
+}
+
+// sortLevels groups tasks by depth in their DAG.
+func sortLevels(tasks map[string]*localTask) ([][]*localTask, error) {
+	depth := make(map[string]int, len(tasks))
+	var visit func(t *localTask, path map[string]bool) (int, error)
+	visit = func(t *localTask, path map[string]bool) (int, error) {
+		if d, ok := depth[t.name]; ok {
+			return d, nil
+		}
+		if path[t.name] {
+			return 0, fmt.Errorf("cycle through task %s", t.name)
+		}
+		path[t.name] = true
+		defer delete(path, t.name)
+		d := 0
+		for _, name := range t.parents {
+			parent, ok := tasks[name]
+			if !ok {
+				return 0, fmt.Errorf("unknown parent %s of task %s", name, t.name)
+			}
+			pd, err := visit(parent, path)
+			if err != nil {
+				return 0, err
+			}
+			d = max(d, pd+1)
+		}
+		depth[t.name] = d
+		return d, nil
+	}
+
+	var levels [][]*localTask
+	for _, t := range tasks {
+		d, err := visit(t, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= d {
+			levels = append(levels, nil)
+		}
+		levels[d] = append(levels[d], t)
+	}
+	// run sibling tasks in a stable order.
+	for _, level := range levels {
+		slices.SortFunc(level, func(a, b *localTask) int {
+			return strings.Compare(a.name, b.name)
+		})
+	}
+	return levels, nil
+}
+
+// WorkflowRun is a run started by RunNoWait.
+type WorkflowRun[O any] struct {
+	ID string
+
+	done chan struct{}
+	out  *O
+	err  error
+}
+
+// Result waits for the run and returns its output. A finished run returns
+// its output even if ctx is done.
+func (w *WorkflowRun[O]) Result(ctx context.Context) (*O, error) {
+	select {
+	case <-w.done:
+		return w.out, w.err
+	default:
+	}
+	select {
+	case <-w.done:
+		return w.out, w.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Run runs wf with input and returns its output. Like runs triggered with
+// hatchet.WithRunMetadata, the trace, app context and metadata of ctx are
+// passed to the tasks in their additional metadata.
+func Run[I, O any](ctx context.Context, r *Runner, wf workflow.WorkflowDeclaration[I, O], input I) (*O, error) {
+	run, err := RunNoWait(ctx, r, wf, input)
+	if err != nil {
+		return nil, err
+	}
+	return run.Result(ctx)
+}
+
+// RunNoWait starts a run of wf with input. Without WithAsync, the run is
+// finished when it returns, and cancelling ctx fails its remaining tasks.
+// With WithAsync, the run outlives ctx but keeps its values.
+func RunNoWait[I, O any](ctx context.Context, r *Runner, wf workflow.WorkflowDeclaration[I, O], input I) (*WorkflowRun[O], error) {
+	dump, _, _, _ := wf.Dump()
+	r.mu.RLock()
+	lw, ok := r.workflows[dump.Name]
+	stopped := r.stopped
+	if ok && !stopped {
+		r.runs.Add(1)
+	}
+	r.mu.RUnlock()
+	switch {
+	case stopped:
+		return nil, errors.New("hatchet local runner stopped")
+	case !ok:
+		return nil, fmt.Errorf("workflow %s not registered", dump.Name)
+	}
+
+	b, err := json.Marshal(input)
+	if err != nil {
+		r.runs.Done()
+		return nil, err
+	}
+	run := &WorkflowRun[O]{
+		ID:   fmt.Sprintf("local-%s-%d", lw.name, r.runSeq.Add(1)),
+		done: make(chan struct{}),
+	}
+	md := hatchet.Metadata(ctx)
+	if r.async {
+		ctx = context.WithoutCancel(ctx)
+	}
+	execute := func() {
+		defer r.runs.Done()
+		defer close(run.done)
+		outputs, err := r.execute(ctx, run.ID, lw, b, md)
+		if err != nil {
+			run.err = err
+			return
+		}
+		run.out, run.err = output[O](lw, outputs)
+	}
+	if r.async {
+		go execute()
+	} else {
+		execute()
+	}
+	return run, nil
+}
+
+// output decodes the output of a workflow from the outputs of its tasks.
+func output[O any](lw *localWorkflow, outputs map[string]json.RawMessage) (*O, error) {
+	var b []byte
+	if lw.standalone {
+		b = outputs[lw.name]
+	} else {
+		var err error
+		if b, err = json.Marshal(outputs); err != nil {
+			return nil, err
+		}
+	}
+	out := new(O)
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, fmt.Errorf("failed to decode output of workflow %s: %w", lw.name, err)
+	}
+	return out, nil
+}
+
+// execute runs the tasks of lw level by level and returns their outputs.
+// The on-failure task runs if any task fails, even once ctx is done.
+func (r *Runner) execute(ctx context.Context, runID string, lw *localWorkflow, input []byte, md map[string]string) (map[string]json.RawMessage, error) {
+	state := &runState{
+		runID:    runID,
+		workflow: lw.name,
+		input:    input,
+		metadata: md,
+		outputs:  map[string]json.RawMessage{},
+		errors:   map[string]string{},
+	}
+	for _, level := range lw.levels {
+		if r.async && len(level) > 1 {
+			var wg sync.WaitGroup
+			for _, t := range level {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r.runTask(ctx, state, t)
+				}()
+			}
+			wg.Wait()
+		} else {
+			for _, t := range level {
+				r.runTask(ctx, state, t)
+			}
+		}
+		if err := state.err(); err != nil {
+			if lw.onFailure != nil {
+				r.runOnFailure(context.WithoutCancel(ctx), state, lw.onFailure)
+			}
+			return nil, err
+		}
+	}
+	return state.outputs, nil
+}
+
+// runTask runs t until it succeeds, runs out of retries or ctx is done,
+// recording its output or error in state.
+func (r *Runner) runTask(ctx context.Context, state *runState, t *localTask) {
+	var err error
+	for attempt := 0; attempt <= t.retries; attempt++ {
+		if attempt > 0 && t.backoffFactor > 0 {
+			backoff := min(time.Duration(math.Pow(t.backoffFactor, float64(attempt))*float64(time.Second)), t.maxBackoff)
+			if !r.wait(ctx, backoff) {
+				err = fmt.Errorf("hatchet local runner stopped before retry: %w", err)
+				break
+			}
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if err != nil {
+				err = fmt.Errorf("run cancelled before retry: %w, last error: %w", ctxErr, err)
+			} else {
+				err = fmt.Errorf("run cancelled: %w", ctxErr)
+			}
+			break
+		}
+		var out any
+		if out, err = r.attempt(ctx, state, t, attempt); err == nil {
+			var b []byte
+			if b, err = json.Marshal(out); err == nil {
+				state.setOutput(t.name, b)
+				return
+			}
+		}
+		log.Warn().Err(err).Msgf("hatchet local task %s/%s failed, attempt %d/%d", state.runID, t.name, attempt+1, t.retries+1)
+	}
+	state.setError(t.name, err)
+}
+
+// wait waits for d, returning false if the runner is stopped first. It
+// returns true early if ctx is done.
+func (r *Runner) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return true
+	case <-r.stop:
+		return false
+	}
+}
+
+// attempt runs t once, failing at its timeout or once runCtx is done even
+// if it does not return.
+func (r *Runner) attempt(runCtx context.Context, state *runState, t *localTask, retryCount int) (any, error) {
+	ctx, cancel := context.WithTimeout(runCtx, t.timeout)
+	defer cancel()
+	hctx := newContext(ctx, state, t.name, retryCount)
+
+	type result struct {
+		out any
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- result{err: fmt.Errorf("[panic] %v", rec)}
+			}
+		}()
+		out, err := t.fn(hctx)
+		done <- result{out: out, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.out, res.err
+	case <-ctx.Done():
+		if err := runCtx.Err(); err != nil {
+			return nil, fmt.Errorf("task %s cancelled: %w", t.name, err)
+		}
+		return nil, fmt.Errorf("task %s timed out after %s: %w", t.name, t.timeout, ctx.Err())
+	}
+}
+
+func (r *Runner) runOnFailure(ctx context.Context, state *runState, fn workflow.WrappedTaskFn) {
+	ctx, cancel := context.WithTimeout(ctx, r.defaultTimeout)
+	defer cancel()
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Error().Err(fmt.Errorf("[panic] %v", rec)).Msgf("hatchet local on-failure task of %s panic", state.runID)
+		}
+	}()
+	if _, err := fn(newContext(ctx, state, "on-failure", 0)); err != nil {
+		log.Error().Err(err).Msgf("hatchet local on-failure task of %s failed", state.runID)
+	}
+}
+
+// runState holds the outputs and errors of the tasks of a run.
+type runState struct {
+	runID    string
+	workflow string
+	input    []byte
+	metadata map[string]string
+
+	mu      sync.Mutex
+	outputs map[string]json.RawMessage
+	errors  map[string]string
+	first   error
+}
+
+func (s *runState) setOutput(task string, b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputs[task] = b
+}
+
+func (s *runState) setError(task string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[task] = err.Error()
+	if s.first == nil {
+		s.first = fmt.Errorf("task %s failed: %w", task, err)
+	}
+}
+
+func (s *runState) output(task string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.outputs[task]
+	return b, ok
+}
+
+func (s *runState) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.first
+}
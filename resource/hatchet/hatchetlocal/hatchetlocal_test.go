@@ -0,0 +1,262 @@
+package hatchetlocal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hatchet-dev/hatchet/pkg/client/create"
+	"github.com/hatchet-dev/hatchet/pkg/client/rest"
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+
+	"github.com/ggsrc/gglib/resource/hatchet"
+)
+
+type orderInput struct {
+	Items []string `json:"items"`
+}
+
+type countOutput struct {
+	Count int `json:"count"`
+}
+
+type summaryOutput struct {
+	Summary string `json:"summary"`
+}
+
+type orderOutput struct {
+	Count   countOutput   `json:"count"`
+	Summary summaryOutput `json:"summary"`
+}
+
+func TestRun_Task(t *testing.T) {
+	r := NewRunner()
+	var attempts atomic.Int32
+	count, err := hatchet.NewTask(r, create.StandaloneTask{Name: "count", Retries: 2},
+		func(ctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+			if attempts.Add(1) < 3 {
+				return nil, errors.New("flaky")
+			}
+			return &countOutput{Count: len(input.Items)}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to register task: %v", err)
+	}
+
+	out, err := Run(context.Background(), r, count, orderInput{Items: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if out.Count != 2 || attempts.Load() != 3 {
+		t.Errorf("Expected a count of 2 after 3 attempts, got %d after %d", out.Count, attempts.Load())
+	}
+
+	if _, err = Run(context.Background(), r, count, orderInput{}); err != nil {
+		t.Errorf("Expected the second run to succeed, got %v", err)
+	}
+}
+
+func TestRun_Workflow(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithAsync()}} {
+		r := NewRunner(opts...)
+		wf, err := hatchet.NewWorkflow[orderInput, orderOutput](r, create.WorkflowCreateOpts[orderInput]{Name: "order"})
+		if err != nil {
+			t.Fatalf("failed to declare workflow: %v", err)
+		}
+		count := hatchet.AddTask(r, wf, create.WorkflowTask[orderInput, orderOutput]{Name: "count"},
+			func(ctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+				return &countOutput{Count: len(input.Items)}, nil
+			})
+		hatchet.AddTask(r, wf, create.WorkflowTask[orderInput, orderOutput]{Name: "summary", Parents: []create.NamedTask{count}},
+			func(ctx worker.HatchetContext, input orderInput) (*summaryOutput, error) {
+				var c countOutput
+				if err := ctx.ParentOutput(count, &c); err != nil {
+					return nil, err
+				}
+				return &summaryOutput{Summary: strings.Join(input.Items, ",")}, nil
+			})
+		if err = r.RegisterWorkflows(wf); err != nil {
+			t.Fatalf("failed to register workflow: %v", err)
+		}
+
+		run, err := RunNoWait(context.Background(), r, wf, orderInput{Items: []string{"a", "b"}})
+		if err != nil {
+			t.Fatalf("failed to start run: %v", err)
+		}
+		out, err := run.Result(context.Background())
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if out.Count.Count != 2 || out.Summary.Summary != "a,b" {
+			t.Errorf("Unexpected output %+v", out)
+		}
+		if err = r.Stop(context.Background()); err != nil {
+			t.Errorf("Failed to stop: %v", err)
+		}
+	}
+}
+
+func TestRun_Failures(t *testing.T) {
+	r := NewRunner()
+	slow, err := hatchet.NewTask(r, create.StandaloneTask{Name: "slow", ExecutionTimeout: 10 * time.Millisecond},
+		func(ctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+			time.Sleep(time.Second)
+			return &countOutput{}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to register task: %v", err)
+	}
+	if _, err = Run(context.Background(), r, slow, orderInput{}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+
+	boom, err := hatchet.NewTask(r, create.StandaloneTask{Name: "boom"},
+		func(ctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+			panic("boom")
+		})
+	if err != nil {
+		t.Fatalf("failed to register task: %v", err)
+	}
+	if _, err = Run(context.Background(), r, boom, orderInput{}); err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Errorf("Expected the panic as an error, got %v", err)
+	}
+}
+
+func TestDeclaration_NotSupported(t *testing.T) {
+	r := NewRunner()
+	count, err := hatchet.NewTask(r, create.StandaloneTask{Name: "count"},
+		func(ctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+			return &countOutput{Count: len(input.Items)}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to register task: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err = count.Run(ctx, orderInput{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Run, got %v", err)
+	}
+	if _, err = count.RunNoWait(ctx, orderInput{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from RunNoWait, got %v", err)
+	}
+	if _, err = count.Schedule(ctx, time.Now(), orderInput{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Schedule, got %v", err)
+	}
+	if err = r.GetHatchetCli().Events().Push(ctx, "order:created", orderInput{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Events().Push, got %v", err)
+	}
+	if _, err = r.GetHatchetCli().Runs().List(ctx, rest.V1WorkflowRunListParams{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Runs().List, got %v", err)
+	}
+
+	// the runner still runs the declaration
+	if _, err = Run(ctx, r, count, orderInput{}); err != nil {
+		t.Errorf("Expected the run to succeed, got %v", err)
+	}
+}
+
+func TestStop_InterruptsBackoff(t *testing.T) {
+	r := NewRunner(WithAsync())
+	attempted := make(chan struct{}, 1)
+	flaky, err := hatchet.NewTask(r, create.StandaloneTask{Name: "flaky", Retries: 1, RetryBackoffFactor: 3600},
+		func(ctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+			attempted <- struct{}{}
+			return nil, errors.New("flaky")
+		})
+	if err != nil {
+		t.Fatalf("failed to register task: %v", err)
+	}
+
+	run, err := RunNoWait(context.Background(), r, flaky, orderInput{})
+	if err != nil {
+		t.Fatalf("failed to start run: %v", err)
+	}
+	<-attempted
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = r.Stop(ctx); err != nil {
+		t.Fatalf("Expected Stop to interrupt the backoff, got %v", err)
+	}
+	if _, err = run.Result(ctx); err == nil || !strings.Contains(err.Error(), "stopped before retry") {
+		t.Errorf("Expected the run to fail on stop, got %v", err)
+	}
+	select {
+	case <-attempted:
+		t.Error("Expected no retry after stop")
+	default:
+	}
+	if err = r.Stop(ctx); err != nil {
+		t.Errorf("Expected a second Stop to succeed, got %v", err)
+	}
+}
+
+func TestRun_CancelFailsTasks(t *testing.T) {
+	r := NewRunner()
+	var attempts atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocked, err := hatchet.NewTask(r, create.StandaloneTask{Name: "blocked", Retries: 3},
+		func(hctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+			attempts.Add(1)
+			cancel()
+			<-hctx.Done()
+			return nil, hctx.Err()
+		})
+	if err != nil {
+		t.Fatalf("failed to register task: %v", err)
+	}
+
+	_, err = Run(ctx, r, blocked, orderInput{})
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "run cancelled before retry") {
+		t.Errorf("Expected the run to be cancelled, got %v", err)
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("Expected no retry once cancelled, got %d attempts", attempts.Load())
+	}
+
+	if _, err = Run(ctx, r, blocked, orderInput{}); err == nil || !strings.Contains(err.Error(), "run cancelled: context canceled") {
+		t.Errorf("Expected a cancelled ctx to fail the run, got %v", err)
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("Expected no attempt with a cancelled ctx, got %d attempts", attempts.Load())
+	}
+}
+
+func TestRunNoWait_AsyncOutlivesCtx(t *testing.T) {
+	r := NewRunner(WithAsync())
+	started, release := make(chan struct{}), make(chan struct{})
+	var taskErr error
+	task, err := hatchet.NewTask(r, create.StandaloneTask{Name: "background"},
+		func(hctx worker.HatchetContext, input orderInput) (*countOutput, error) {
+			close(started)
+			<-release
+			taskErr = hctx.Err()
+			return &countOutput{Count: len(input.Items)}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to register task: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run, err := RunNoWait(ctx, r, task, orderInput{Items: []string{"a"}})
+	if err != nil {
+		t.Fatalf("failed to start run: %v", err)
+	}
+	<-started
+	cancel()
+	close(release)
+	out, err := run.Result(context.Background())
+	if err != nil || out.Count != 1 {
+		t.Fatalf("Expected the run to succeed after its ctx is cancelled, got %+v, %v", out, err)
+	}
+	if taskErr != nil {
+		t.Errorf("Expected the task ctx not to be cancelled, got %v", taskErr)
+	}
+	if err = r.Stop(context.Background()); err != nil {
+		t.Errorf("Failed to stop: %v", err)
+	}
+}
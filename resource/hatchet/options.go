@@ -0,0 +1,27 @@
+package hatchet
+
+import (
+	"time"
+
+	"github.com/ggsrc/gglib/interceptor/grpc/recovery"
+)
+
+type Option func(h *Hatchet)
+
+// WithStartRetry retries connecting to the engine in Start up to attempts
+// times, 5 by default, waiting backoff, 1s by default, doubled after each
+// failure up to 30s.
+func WithStartRetry(attempts int, backoff time.Duration) Option {
+	return func(h *Hatchet) {
+		h.startAttempts = attempts
+		h.startBackoff = backoff
+	}
+}
+
+// WithPanicHandler reports panics of the tasks wrapped by Instrument, with
+// the task name as method.
+func WithPanicHandler(handler recovery.PanicHandler) Option {
+	return func(h *Hatchet) {
+		h.panicHandler = handler
+	}
+}
@@ -0,0 +1,89 @@
+package hatchet
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+
+	v0client "github.com/hatchet-dev/hatchet/pkg/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc/metadata"
+
+	pkgmetadata "github.com/ggsrc/gglib/interceptor/metadata"
+	"github.com/ggsrc/gglib/mctx"
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+// Metadata serializes the trace context, the app context and the metadata
+// of ctx into additional metadata of a workflow run or event, restored into
+// the context of the tasks by Instrument.
+func Metadata(ctx context.Context) map[string]string {
+	md := map[string]string{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(md))
+
+	if appCtx, ok := mctx.AppCtxFromContext(ctx); ok && appCtx != nil {
+		if b, err := json.Marshal(appCtx); err == nil {
+			md[pkgmetadata.CTX_KEY_APP_CTX] = string(b)
+		} else {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to marshal app ctx")
+		}
+	} else if incoming, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := incoming.Get(pkgmetadata.CTX_KEY_APP_CTX); len(v) > 0 {
+			md[pkgmetadata.CTX_KEY_APP_CTX] = v[0]
+		}
+	}
+
+	// set by the context interceptor of the grpc server.
+	if v, ok := ctx.Value(pkgmetadata.ContextKeyMetadata).(string); ok && v != "" {
+		md[pkgmetadata.CTX_KEY_METADATA] = v
+	}
+	return md
+}
+
+// WithRunMetadata is v0client.WithRunMetadata with the Metadata of ctx,
+// extra taking precedence:
+//
+//	wf.RunNoWait(ctx, input, hatchet.WithRunMetadata(ctx, nil))
+func WithRunMetadata(ctx context.Context, extra map[string]string) v0client.RunOptFunc {
+	md := Metadata(ctx)
+	maps.Copy(md, extra)
+	return v0client.WithRunMetadata(md)
+}
+
+// WithEventMetadata is v0client.WithEventMetadata with the Metadata of ctx,
+// extra taking precedence.
+func WithEventMetadata(ctx context.Context, extra map[string]string) v0client.PushOpFunc {
+	md := Metadata(ctx)
+	maps.Copy(md, extra)
+	return v0client.WithEventMetadata(md)
+}
+
+// ContextWithMetadata restores into ctx what Metadata serialized. The app
+// context and metadata are also set as incoming grpc metadata, so that the
+// context interceptor of grpc clients forwards them.
+func ContextWithMetadata(ctx context.Context, md map[string]string) context.Context {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(md))
+
+	forward := metadata.MD{}
+	if s, ok := md[pkgmetadata.CTX_KEY_APP_CTX]; ok {
+		// decoded like the context interceptor of grpc servers does.
+		if appCtx, err := mctx.StringToAppCtx(s); err == nil {
+			ctx = mctx.ContextWithAppCtx(ctx, appCtx)
+			forward.Set(pkgmetadata.CTX_KEY_APP_CTX, s)
+		} else {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to convert app ctx")
+		}
+	}
+	if s, ok := md[pkgmetadata.CTX_KEY_METADATA]; ok {
+		ctx = context.WithValue(ctx, pkgmetadata.ContextKeyMetadata, s)
+		forward.Set(pkgmetadata.CTX_KEY_METADATA, s)
+	}
+	if forward.Len() > 0 {
+		if incoming, ok := metadata.FromIncomingContext(ctx); ok {
+			forward = metadata.Join(incoming, forward)
+		}
+		ctx = metadata.NewIncomingContext(ctx, forward)
+	}
+	return ctx
+}
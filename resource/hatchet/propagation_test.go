@@ -0,0 +1,43 @@
+package hatchet
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+
+	pkgmetadata "github.com/ggsrc/gglib/interceptor/metadata"
+	"github.com/ggsrc/gglib/mctx"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	ctx = mctx.ContextWithAppCtx(ctx, &mctx.AppContext{CommonParams: &mctx.ReqCommonParams{AppID: 7}})
+	ctx = context.WithValue(ctx, pkgmetadata.ContextKeyMetadata, `{"k":"v"}`)
+
+	restored := ContextWithMetadata(context.Background(), Metadata(ctx))
+
+	if got := trace.SpanContextFromContext(restored); got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() {
+		t.Errorf("Expected the span context to be restored, got %v", got)
+	}
+	appCtx, ok := mctx.AppCtxFromContext(restored)
+	if !ok || appCtx.CommonParams.AppID != 7 {
+		t.Errorf("Expected the app context to be restored, got %+v", appCtx)
+	}
+	if got := restored.Value(pkgmetadata.ContextKeyMetadata); got != `{"k":"v"}` {
+		t.Errorf("Expected the metadata to be restored, got %v", got)
+	}
+	md, _ := metadata.FromIncomingContext(restored)
+	if len(md.Get(pkgmetadata.CTX_KEY_APP_CTX)) != 1 || len(md.Get(pkgmetadata.CTX_KEY_METADATA)) != 1 {
+		t.Errorf("Expected the app context and metadata to be forwarded, got %v", md)
+	}
+}
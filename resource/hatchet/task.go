@@ -0,0 +1,173 @@
+package hatchet
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/hatchet-dev/hatchet/pkg/client/create"
+	hatchetcli "github.com/hatchet-dev/hatchet/pkg/v1"
+	"github.com/hatchet-dev/hatchet/pkg/v1/factory"
+	"github.com/hatchet-dev/hatchet/pkg/v1/task"
+	"github.com/hatchet-dev/hatchet/pkg/v1/workflow"
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+const name = "github.com/ggsrc/gglib/resource/hatchet"
+
+var (
+	tracer = otel.Tracer(name)
+
+	taskRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gglib_hatchet_task_runs_total",
+			Help: "task runs by result: {success, error, panic}.",
+		}, []string{"task", "result"})
+	taskDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gglib_hatchet_task_duration_seconds",
+			Help:    "task run duration.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+		}, []string{"task"})
+)
+
+func init() {
+	prometheus.MustRegister(taskRuns, taskDuration)
+}
+
+// TaskFunc is a task taking an input of type I and returning an output of
+// type O.
+type TaskFunc[I, O any] func(ctx worker.HatchetContext, input I) (*O, error)
+
+// Registry is where workflows are registered and run: a Hatchet resource,
+// or the in-process runner of package hatchetlocal.
+type Registry interface {
+	// GetHatchetCli returns the client declarations are created with, and
+	// trigger runs with if the registry does not run them itself.
+	GetHatchetCli() hatchetcli.HatchetClient
+	RegisterWorkflows(workflows ...workflow.WorkflowBase) error
+}
+
+// NewTask declares a standalone task instrumented by Instrument and
+// registers it with r. With a Hatchet resource, it must be called after
+// Init and before Start.
+func NewTask[I, O any](r Registry, opts create.StandaloneTask, fn TaskFunc[I, O]) (workflow.WorkflowDeclaration[I, O], error) {
+	if err := checkInitialized(r); err != nil {
+		return nil, err
+	}
+	decl := factory.NewTask(opts, Instrument(hatchetOf(r), opts.Name, fn), r.GetHatchetCli())
+	if err := r.RegisterWorkflows(decl); err != nil {
+		return nil, err
+	}
+	return decl, nil
+}
+
+// NewWorkflow declares a workflow, to which tasks are added with AddTask
+// before registering it with RegisterWorkflows. With a Hatchet resource, it
+// must be called after Init.
+func NewWorkflow[I, O any](r Registry, opts create.WorkflowCreateOpts[I]) (workflow.WorkflowDeclaration[I, O], error) {
+	if err := checkInitialized(r); err != nil {
+		return nil, err
+	}
+	return factory.NewWorkflow[I, O](opts, r.GetHatchetCli()), nil
+}
+
+// AddTask adds a task instrumented by Instrument to wf. Its output T is
+// the input of the tasks depending on it through opts.Parents.
+func AddTask[I, O, T any](r Registry, wf workflow.WorkflowDeclaration[I, O], opts create.WorkflowTask[I, O], fn TaskFunc[I, T]) *task.TaskDeclaration[I] {
+	instrumented := Instrument(hatchetOf(r), opts.Name, fn)
+	return wf.Task(opts, func(ctx worker.HatchetContext, input I) (any, error) {
+		return instrumented(ctx, input)
+	})
+}
+
+// RegisterWorkflows registers workflows with the worker. It must be called
+// after Init and before Start.
+func (h *Hatchet) RegisterWorkflows(workflows ...workflow.WorkflowBase) error {
+	if !h.initialized {
+		return errors.New("hatchet not initialized")
+	}
+	if err := h.hatchetWorker.RegisterWorkflows(workflows...); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.workflows += len(workflows)
+	h.mu.Unlock()
+	return nil
+}
+
+func checkInitialized(r Registry) error {
+	if h, ok := r.(*Hatchet); ok && !h.initialized {
+		return errors.New("hatchet not initialized")
+	}
+	return nil
+}
+
+// hatchetOf returns r if it is a Hatchet resource, nil otherwise.
+func hatchetOf(r Registry) *Hatchet {
+	h, _ := r.(*Hatchet)
+	return h
+}
+
+// Instrument wraps fn so that each run:
+//   - runs in a span named after the task, with its logger in the context,
+//     child of the span, app context and metadata that triggered it, see
+//     ContextWithMetadata
+//   - is counted and timed in the gglib_hatchet_task_* metrics
+//   - recovers from panics, reported to the panic handler of WithPanicHandler
+//   - is waited for by Stop
+//
+// h may be nil, for tasks not run by a Hatchet resource.
+func Instrument[I, O any](h *Hatchet, taskName string, fn TaskFunc[I, O]) TaskFunc[I, O] {
+	return TrackTask(h, func(hctx worker.HatchetContext, input I) (out *O, err error) {
+		start := time.Now()
+		ctx := ContextWithMetadata(hctx.GetContext(), hctx.AdditionalMetadata())
+		ctx, span := tracer.Start(ctx, "hatchet.task "+taskName,
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("hatchet.task", taskName),
+				attribute.String("hatchet.workflow_run_id", hctx.WorkflowRunId()),
+				attribute.String("hatchet.step_run_id", hctx.StepRunId()),
+				attribute.Int("hatchet.retry_count", hctx.RetryCount()),
+			))
+		logger := log.Ctx(ctx).With().
+			Str("task", taskName).
+			Str("workflow_run_id", hctx.WorkflowRunId()).
+			Str("step_run_id", hctx.StepRunId()).
+			Logger()
+		ctx = logger.WithContext(ctx)
+		hctx.SetContext(ctx)
+
+		result := "success"
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				result = "panic"
+				err = fmt.Errorf("[panic] %v", r)
+				logger.Error().Str("panic.stack", string(stack)).Err(err).Msgf("hatchet task %s panic", taskName)
+				if h != nil && h.panicHandler != nil {
+					h.panicHandler(ctx, taskName, r, stack)
+				}
+			} else if err != nil {
+				result = "error"
+				logger.Error().Err(err).Msgf("hatchet task %s failed", taskName)
+			}
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+			taskRuns.WithLabelValues(taskName, result).Inc()
+			taskDuration.WithLabelValues(taskName).Observe(time.Since(start).Seconds())
+		}()
+		return fn(hctx, input)
+	})
+}
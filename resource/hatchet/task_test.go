@@ -0,0 +1,74 @@
+package hatchet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	hatchetworker "github.com/hatchet-dev/hatchet/pkg/v1/worker"
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeContext implements the methods of worker.HatchetContext used by
+// Instrument.
+type fakeContext struct {
+	worker.HatchetContext
+	ctx context.Context
+	md  map[string]string
+}
+
+func (c *fakeContext) GetContext() context.Context           { return c.ctx }
+func (c *fakeContext) SetContext(ctx context.Context)        { c.ctx = ctx }
+func (c *fakeContext) WorkflowRunId() string                 { return "run" }
+func (c *fakeContext) StepRunId() string                     { return "step" }
+func (c *fakeContext) RetryCount() int                       { return 0 }
+func (c *fakeContext) AdditionalMetadata() map[string]string { return c.md }
+
+type greetInput struct {
+	Name string
+}
+
+type greetOutput struct {
+	Greeting string
+}
+
+func TestInstrument(t *testing.T) {
+	var panics []string
+	h := NewHatchet(nil, hatchetworker.WorkerOpts{}, WithPanicHandler(func(ctx context.Context, method string, r any, stack []byte) {
+		panics = append(panics, method)
+	}))
+
+	greet := Instrument(h, "greet", func(ctx worker.HatchetContext, input greetInput) (*greetOutput, error) {
+		switch input.Name {
+		case "":
+			return nil, errors.New("missing name")
+		case "panic":
+			panic("boom")
+		}
+		return &greetOutput{Greeting: "hello " + input.Name}, nil
+	})
+
+	out, err := greet(&fakeContext{ctx: context.Background()}, greetInput{Name: "alice"})
+	if err != nil || out.Greeting != "hello alice" {
+		t.Errorf("Unexpected result %+v, %v", out, err)
+	}
+	if _, err = greet(&fakeContext{ctx: context.Background()}, greetInput{}); err == nil {
+		t.Error("Expected an error")
+	}
+	if _, err = greet(&fakeContext{ctx: context.Background()}, greetInput{Name: "panic"}); err == nil {
+		t.Error("Expected the panic to be returned as an error")
+	}
+
+	if len(panics) != 1 || panics[0] != "greet" {
+		t.Errorf("Expected the panic handler to be called once for greet, got %v", panics)
+	}
+	for result, want := range map[string]float64{"success": 1, "error": 1, "panic": 1} {
+		if got := testutil.ToFloat64(taskRuns.WithLabelValues("greet", result)); got != want {
+			t.Errorf("Expected %v %s runs, got %v", want, result, got)
+		}
+	}
+	if h.inflight.Load() != 0 {
+		t.Errorf("Expected no in-flight runs, got %d", h.inflight.Load())
+	}
+}
@@ -2,6 +2,7 @@ package resource
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -13,9 +14,18 @@ type Resource interface {
 	Init(ctx context.Context) error
 	Start(ctx context.Context) error
 	Stop(ctx context.Context) error
+	// OK returns an error when the resource is unhealthy. An error with a
+	// NonFatal method returning true reports a degraded resource that keeps
+	// working, such as a read replica routed around.
 	OK(ctx context.Context) error
 }
 
+// IsNonFatal reports whether err, returned by OK, is non-fatal.
+func IsNonFatal(err error) bool {
+	var nf interface{ NonFatal() bool }
+	return errors.As(err, &nf) && nf.NonFatal()
+}
+
 type HealthStatus struct {
 	Status bool
 	Error  error
@@ -84,12 +94,19 @@ func (rm *resourceManager) Stop(ctx context.Context) error {
 	return nil
 }
 
+// OK returns the first fatal error of the resources. Otherwise non-fatal
+// errors are returned joined, so that they are reported.
 func (rm *resourceManager) OK(ctx context.Context) error {
+	var nonFatal []error
 	for _, r := range rm.resources {
 		err := r.OK(ctx)
-		if err != nil {
+		if err == nil {
+			continue
+		}
+		if !IsNonFatal(err) {
 			return err
 		}
+		nonFatal = append(nonFatal, errors.Wrap(err, r.Name()))
 	}
-	return nil
+	return stderrors.Join(nonFatal...)
 }
@@ -0,0 +1,110 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stumble/wpgx"
+)
+
+// gate holds queries back until open is closed.
+type gate struct {
+	open <-chan struct{}
+}
+
+// Gate makes the queries run through WConn, WQuerier and WithTx wait until
+// open is closed, or fail when their ctx is done. They wait before
+// acquiring a connection, so waiting callers do not hold pool slots. It is
+// used by async migrations so that nothing queries a schema being migrated.
+// GetPool and OK are not gated.
+func (w *WPGX) Gate(open <-chan struct{}) error {
+	if open == nil {
+		return errors.New("open cannot be nil")
+	}
+	if !w.gate.CompareAndSwap(nil, &gate{open: open}) {
+		return errors.New("wpgx is already gated")
+	}
+	return nil
+}
+
+// waitGate waits for the gate, if any, to be open.
+func (w *WPGX) waitGate(ctx context.Context) error {
+	current := w.gate.Load()
+	if current == nil {
+		return nil
+	}
+	select {
+	case <-current.open:
+		// nothing left to wait for.
+		w.gate.CompareAndSwap(current, nil)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for migrations: %w", ctx.Err())
+	}
+}
+
+// gatedQuerier waits for the gate of w before each query.
+type gatedQuerier struct {
+	q wpgx.WQuerier
+	w *WPGX
+}
+
+var _ wpgx.WQuerier = (*gatedQuerier)(nil)
+
+func (g *gatedQuerier) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (pgx.Rows, error) {
+	if err := g.w.waitGate(ctx); err != nil {
+		return nil, err
+	}
+	return g.q.WQuery(ctx, name, unprepared, args...)
+}
+
+func (g *gatedQuerier) WQueryRow(ctx context.Context, name string, unprepared string, args ...interface{}) pgx.Row {
+	if err := g.w.waitGate(ctx); err != nil {
+		return errRow{err: err}
+	}
+	return g.q.WQueryRow(ctx, name, unprepared, args...)
+}
+
+func (g *gatedQuerier) CountIntent(name string) {
+	g.q.CountIntent(name)
+}
+
+// gatedConn is a gatedQuerier over a wpgx.WGConn.
+type gatedConn struct {
+	gatedQuerier
+	c wpgx.WGConn
+}
+
+var _ wpgx.WGConn = (*gatedConn)(nil)
+
+func (g *gatedConn) WExec(ctx context.Context, name string, unprepared string, args ...interface{}) (pgconn.CommandTag, error) {
+	if err := g.w.waitGate(ctx); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	return g.c.WExec(ctx, name, unprepared, args...)
+}
+
+func (g *gatedConn) PostExec(f wpgx.PostExecFunc) error {
+	return g.c.PostExec(f)
+}
+
+func (g *gatedConn) WCopyFrom(
+	ctx context.Context, name string, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource,
+) (int64, error) {
+	if err := g.w.waitGate(ctx); err != nil {
+		return 0, err
+	}
+	return g.c.WCopyFrom(ctx, name, tableName, columnNames, rowSrc)
+}
+
+// errRow is a pgx.Row failing with err.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...any) error {
+	return r.err
+}
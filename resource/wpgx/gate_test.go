@@ -0,0 +1,68 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// countingQuerier counts the queries reaching it.
+type countingQuerier struct {
+	sleepQuerier
+	queries int
+}
+
+func (q *countingQuerier) WQueryRow(ctx context.Context, name string, unprepared string, args ...interface{}) pgx.Row {
+	q.queries++
+	return q.sleepQuerier.WQueryRow(ctx, name, unprepared, args...)
+}
+
+func TestWPGX_WaitGate(t *testing.T) {
+	w := &WPGX{}
+	ctx := context.Background()
+	if err := w.waitGate(ctx); err != nil {
+		t.Errorf("Expected no gate to be open, got %v", err)
+	}
+
+	open := make(chan struct{})
+	if err := w.Gate(open); err != nil {
+		t.Fatalf("Gate failed: %v", err)
+	}
+	inner := &countingQuerier{}
+	q := &gatedQuerier{q: inner, w: w}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := q.WQueryRow(timeoutCtx, "select_one", "SELECT 1").Scan(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the gate to hold until the ctx is done, got %v", err)
+	}
+	if inner.queries != 0 {
+		t.Errorf("Expected no query to run before the gate opens, got %d", inner.queries)
+	}
+
+	close(open)
+	if err := q.WQueryRow(ctx, "select_one", "SELECT 1").Scan(); err != nil {
+		t.Errorf("Expected the gate to be open, got %v", err)
+	}
+	if inner.queries != 1 {
+		t.Errorf("Expected the query to run once the gate opens, got %d", inner.queries)
+	}
+	if w.gate.Load() != nil {
+		t.Errorf("Expected the open gate to be cleared")
+	}
+}
+
+func TestWPGX_Gate(t *testing.T) {
+	w := &WPGX{}
+	if err := w.Gate(nil); err == nil {
+		t.Errorf("Expected a nil channel to be rejected")
+	}
+	if err := w.Gate(make(chan struct{})); err != nil {
+		t.Fatalf("Gate failed: %v", err)
+	}
+	if err := w.Gate(make(chan struct{})); err == nil {
+		t.Errorf("Expected a second gate to be rejected")
+	}
+}
@@ -0,0 +1,138 @@
+package wpgx
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stumble/wpgx"
+)
+
+// poolCollector exports pgxpool.Stat of the primary and of every replica
+// pool when scraped, labelled by replica (primary for the primary pool).
+type poolCollector struct {
+	pool *wpgx.Pool
+
+	acquiredConnsDesc       *prometheus.Desc
+	idleConnsDesc           *prometheus.Desc
+	constructingConnsDesc   *prometheus.Desc
+	totalConnsDesc          *prometheus.Desc
+	maxConnsDesc            *prometheus.Desc
+	acquiresDesc            *prometheus.Desc
+	acquireDurationDesc     *prometheus.Desc
+	emptyAcquiresDesc       *prometheus.Desc
+	emptyAcquireWaitDesc    *prometheus.Desc
+	canceledAcquiresDesc    *prometheus.Desc
+	newConnsDesc            *prometheus.Desc
+	maxLifetimeDestroysDesc *prometheus.Desc
+	maxIdleDestroysDesc     *prometheus.Desc
+}
+
+func newPoolCollector(appName string, pool *wpgx.Pool) *poolCollector {
+	labels := prometheus.Labels{"app": appName}
+	replica := []string{"replica"}
+	return &poolCollector{
+		pool: pool,
+		acquiredConnsDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_acquired_conns",
+			"number of connections currently acquired from the pool.",
+			replica, labels),
+		idleConnsDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_idle_conns",
+			"number of idle connections in the pool.",
+			replica, labels),
+		constructingConnsDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_constructing_conns",
+			"number of connections being established.",
+			replica, labels),
+		totalConnsDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_total_conns",
+			"number of connections in the pool, acquired, idle or constructing.",
+			replica, labels),
+		maxConnsDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_max_conns",
+			"maximum size of the pool.",
+			replica, labels),
+		acquiresDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_acquires_total",
+			"successful acquires from the pool.",
+			replica, labels),
+		acquireDurationDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_acquire_duration_seconds_total",
+			"total time spent in successful acquires.",
+			replica, labels),
+		emptyAcquiresDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_empty_acquires_total",
+			"successful acquires that waited for a connection because the pool was empty.",
+			replica, labels),
+		emptyAcquireWaitDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_empty_acquire_wait_seconds_total",
+			"total time spent waiting for a connection in acquires from an empty pool.",
+			replica, labels),
+		canceledAcquiresDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_canceled_acquires_total",
+			"acquires cancelled by their context.",
+			replica, labels),
+		newConnsDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_new_conns_total",
+			"connections opened.",
+			replica, labels),
+		maxLifetimeDestroysDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_max_lifetime_destroys_total",
+			"connections closed because they reached MaxConnLifetime.",
+			replica, labels),
+		maxIdleDestroysDesc: prometheus.NewDesc(
+			"gglib_wpgx_pool_max_idle_destroys_total",
+			"connections closed because they reached MaxConnIdleTime.",
+			replica, labels),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConnsDesc
+	ch <- c.idleConnsDesc
+	ch <- c.constructingConnsDesc
+	ch <- c.totalConnsDesc
+	ch <- c.maxConnsDesc
+	ch <- c.acquiresDesc
+	ch <- c.acquireDurationDesc
+	ch <- c.emptyAcquiresDesc
+	ch <- c.emptyAcquireWaitDesc
+	ch <- c.canceledAcquiresDesc
+	ch <- c.newConnsDesc
+	ch <- c.maxLifetimeDestroysDesc
+	ch <- c.maxIdleDestroysDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	primary := c.pool.RawPrimaryPool()
+	c.collect(ch, primary, wpgx.ReservedReplicaNamePrimary)
+	for name, pool := range c.pool.ReplicaPools() {
+		// broken replicas share the primary pool.
+		if pool == primary {
+			continue
+		}
+		c.collect(ch, pool, string(name))
+	}
+}
+
+func (c *poolCollector) collect(ch chan<- prometheus.Metric, pool *pgxpool.Pool, replica string) {
+	s := pool.Stat()
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, replica)
+	}
+	counter := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, replica)
+	}
+	gauge(c.acquiredConnsDesc, float64(s.AcquiredConns()))
+	gauge(c.idleConnsDesc, float64(s.IdleConns()))
+	gauge(c.constructingConnsDesc, float64(s.ConstructingConns()))
+	gauge(c.totalConnsDesc, float64(s.TotalConns()))
+	gauge(c.maxConnsDesc, float64(s.MaxConns()))
+	counter(c.acquiresDesc, float64(s.AcquireCount()))
+	counter(c.acquireDurationDesc, s.AcquireDuration().Seconds())
+	counter(c.emptyAcquiresDesc, float64(s.EmptyAcquireCount()))
+	counter(c.emptyAcquireWaitDesc, s.EmptyAcquireWaitTime().Seconds())
+	counter(c.canceledAcquiresDesc, float64(s.CanceledAcquireCount()))
+	counter(c.newConnsDesc, float64(s.NewConnsCount()))
+	counter(c.maxLifetimeDestroysDesc, float64(s.MaxLifetimeDestroyCount()))
+	counter(c.maxIdleDestroysDesc, float64(s.MaxIdleDestroyCount()))
+}
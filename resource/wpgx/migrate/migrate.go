@@ -0,0 +1,307 @@
+// Package migrate applies versioned SQL migrations to the primary database
+// of a wpgx.WPGX resource.
+//
+// Migrations are files named <version>_<name>.up.sql, with an optional
+// <version>_<name>.down.sql, at the root of an fs.FS, usually an embed.FS
+// (use fs.Sub for a subdirectory). Each one runs in its own transaction and
+// is recorded in Config.Table. A Postgres advisory lock ensures only one
+// replica migrates at a time; the others wait for it and find nothing left.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ggsrc/gglib/resource/wpgx"
+)
+
+var errRunning = errors.New("migrations are running")
+
+type Config struct {
+	Table string `default:"schema_migrations"`
+	// Target is the version to migrate to, 0 for the latest. With Down,
+	// applied migrations above Target are rolled back.
+	Target int64 `default:"0"`
+	Down   bool  `default:"false"`
+	// DryRun logs the migrations that would run without running them.
+	DryRun bool `default:"false"`
+	// Async runs migrations in Start instead of Init. OK fails until they are
+	// finished, and the queries of the WPGX wait for them, see
+	// wpgx.WPGX.Gate.
+	Async bool `default:"false"`
+	// LockID is the advisory lock key, 0 derives it from Table.
+	LockID int64 `default:"0"`
+}
+
+// Migrator is a resource applying migrations. It must be registered after
+// the WPGX resource it migrates.
+type Migrator struct {
+	wpgx       *wpgx.WPGX
+	fsys       fs.FS
+	conf       *Config
+	migrations []*migration
+
+	initialized bool
+	cancel      context.CancelFunc
+	done        chan struct{}
+	finishOnce  sync.Once
+	err         error
+}
+
+func NewMigratorWithDefaultEnvPrefix(w *wpgx.WPGX, fsys fs.FS) *Migrator {
+	return NewMigratorWithEnvPrefix(w, fsys, "WPGX_MIGRATION")
+}
+
+func NewMigratorWithEnvPrefix(w *wpgx.WPGX, fsys fs.FS, envPrefix string) *Migrator {
+	conf := &Config{}
+	envconfig.MustProcess(envPrefix, conf)
+	return NewMigrator(w, fsys, conf)
+}
+
+func NewMigrator(w *wpgx.WPGX, fsys fs.FS, conf *Config) *Migrator {
+	if w == nil || fsys == nil || conf == nil {
+		panic("wpgx, fsys and conf cannot be nil")
+	}
+	if conf.LockID == 0 {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte("gglib_migrate:" + conf.Table))
+		// nolint: gosec
+		conf.LockID = int64(h.Sum64())
+	}
+	return &Migrator{
+		wpgx: w,
+		fsys: fsys,
+		conf: conf,
+		done: make(chan struct{}),
+	}
+}
+
+func (m *Migrator) Name() string {
+	return "wpgx_migrate"
+}
+
+// Init applies the migrations unless Config.Async is set, in which case it
+// gates the queries of the WPGX until they are applied by Start.
+func (m *Migrator) Init(ctx context.Context) error {
+	migrations, err := loadMigrations(m.fsys)
+	if err != nil {
+		return err
+	}
+	m.migrations = migrations
+	if m.wpgx.GetPool() == nil {
+		return errors.New("wpgx must be initialized before migrations")
+	}
+	if !m.conf.Async {
+		m.initialized = true
+		m.finish(m.run(ctx))
+		return m.err
+	}
+	if err = m.wpgx.Gate(m.done); err != nil {
+		return err
+	}
+	m.initialized = true
+	return nil
+}
+
+func (m *Migrator) Start(ctx context.Context) error {
+	if !m.initialized {
+		return errors.New("migrator not initialized")
+	}
+	if m.conf.Async {
+		// Start ctx is cancelled once every resource is started, migrations
+		// are only cancelled by Stop.
+		var runCtx context.Context
+		runCtx, m.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		go func() {
+			err := m.run(runCtx)
+			if err != nil {
+				log.Ctx(runCtx).Err(err).Msg("failed to migrate database")
+			}
+			m.finish(err)
+		}()
+	}
+	return nil
+}
+
+func (m *Migrator) Stop(ctx context.Context) error {
+	if m.cancel == nil {
+		// opens the gate of migrations that never started.
+		m.finish(errors.New("migrator stopped before start"))
+		return nil
+	}
+	m.cancel()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// OK fails until the migrations are applied, so that the service is not
+// ready before.
+func (m *Migrator) OK(ctx context.Context) error {
+	select {
+	case <-m.done:
+		return m.err
+	default:
+		return errRunning
+	}
+}
+
+// Wait blocks until the migrations are finished and returns their error.
+func (m *Migrator) Wait(ctx context.Context) error {
+	select {
+	case <-m.done:
+		return m.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *Migrator) finish(err error) {
+	m.finishOnce.Do(func() {
+		m.err = err
+		close(m.done)
+	})
+}
+
+func (m *Migrator) run(ctx context.Context) error {
+	// a connection of its own, so that queries waiting for async migrations
+	// in the pool cannot starve them.
+	conn, err := m.wpgx.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		_ = conn.Close(context.WithoutCancel(ctx))
+	}()
+
+	// the lock is held by the session, all statements run on this connection.
+	log.Ctx(ctx).Info().Msgf("waiting for migration lock %d", m.conf.LockID)
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", m.conf.LockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", m.conf.LockID); err != nil {
+			log.Ctx(ctx).Err(err).Msg("failed to release migration lock")
+		}
+	}()
+
+	if !m.conf.DryRun {
+		_, err = conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`, m.table()))
+		if err != nil {
+			return fmt.Errorf("failed to create migrations table: %w", err)
+		}
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if m.conf.Down {
+		return m.down(ctx, conn, applied)
+	}
+	return m.up(ctx, conn, applied)
+}
+
+func (m *Migrator) up(ctx context.Context, conn *pgx.Conn, applied map[int64]bool) error {
+	count := 0
+	for _, mig := range m.migrations {
+		if applied[mig.version] || (m.conf.Target > 0 && mig.version > m.conf.Target) {
+			continue
+		}
+		count++
+		if m.conf.DryRun {
+			log.Ctx(ctx).Info().Msgf("dry run: would apply migration %d_%s", mig.version, mig.name)
+			continue
+		}
+		log.Ctx(ctx).Info().Msgf("applying migration %d_%s", mig.version, mig.name)
+		err := m.exec(ctx, conn, mig.up,
+			fmt.Sprintf("INSERT INTO %s (version, name) VALUES ($1, $2)", m.table()), mig.version, mig.name)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", mig.version, mig.name, err)
+		}
+	}
+	log.Ctx(ctx).Info().Msgf("database is up to date, %d migrations applied", count)
+	return nil
+}
+
+func (m *Migrator) down(ctx context.Context, conn *pgx.Conn, applied map[int64]bool) error {
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if !applied[mig.version] || mig.version <= m.conf.Target {
+			continue
+		}
+		if !mig.hasDown {
+			return fmt.Errorf("migration %d_%s has no down file", mig.version, mig.name)
+		}
+		count++
+		if m.conf.DryRun {
+			log.Ctx(ctx).Info().Msgf("dry run: would roll back migration %d_%s", mig.version, mig.name)
+			continue
+		}
+		log.Ctx(ctx).Info().Msgf("rolling back migration %d_%s", mig.version, mig.name)
+		err := m.exec(ctx, conn, mig.down,
+			fmt.Sprintf("DELETE FROM %s WHERE version = $1", m.table()), mig.version)
+		if err != nil {
+			return fmt.Errorf("failed to roll back migration %d_%s: %w", mig.version, mig.name, err)
+		}
+	}
+	log.Ctx(ctx).Info().Msgf("database is at version %d, %d migrations rolled back", m.conf.Target, count)
+	return nil
+}
+
+// exec runs a migration and records it in one transaction.
+func (m *Migrator) exec(ctx context.Context, conn *pgx.Conn, sql string, record string, args ...any) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		// without arguments Exec uses the simple protocol, which allows
+		// several statements per migration.
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, record, args...)
+		return err
+	})
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *pgx.Conn) (map[int64]bool, error) {
+	applied := map[int64]bool{}
+	var exists bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", m.table()).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check migrations table: %w", err)
+	}
+	if !exists {
+		return applied, nil
+	}
+	rows, err := conn.Query(ctx, fmt.Sprintf("SELECT version FROM %s", m.table()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for _, v := range versions {
+		applied[v] = true
+	}
+	return applied, nil
+}
+
+// table returns the quoted Config.Table, which may be qualified by a schema.
+func (m *Migrator) table() string {
+	return pgx.Identifier(strings.Split(m.conf.Table, ".")).Sanitize()
+}
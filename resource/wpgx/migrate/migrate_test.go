@@ -0,0 +1,200 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ggsrc/gglib/resource/wpgx/migrate"
+	"github.com/ggsrc/gglib/resource/wpgx/wpgxtest"
+)
+
+var migrations = fstest.MapFS{
+	"1_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id bigint PRIMARY KEY);")},
+	"1_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	"2_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email text;\nCREATE INDEX users_email ON users (email);")},
+	"2_add_email.down.sql":    {Data: []byte("DROP INDEX users_email;\nALTER TABLE users DROP email;")},
+	"3_create_orders.up.sql":  {Data: []byte("CREATE TABLE orders (id bigint PRIMARY KEY);")},
+}
+
+// table is mixed case, so that it only matches when quoted.
+const table = "SchemaMigrations"
+
+func migrateTo(t *testing.T, db *wpgxtest.DB, conf *migrate.Config) error {
+	t.Helper()
+	conf.Table = table
+	return migrate.NewMigrator(db.WPGX, migrations, conf).Init(context.Background())
+}
+
+func versions(t *testing.T, db *wpgxtest.DB) []int64 {
+	t.Helper()
+	rows, err := db.GetPool().RawPrimaryPool().Query(context.Background(), `SELECT version FROM "SchemaMigrations" ORDER BY version`)
+	if err != nil {
+		t.Fatalf("failed to read versions: %v", err)
+	}
+	defer rows.Close()
+	var vs []int64
+	for rows.Next() {
+		var v int64
+		if err = rows.Scan(&v); err != nil {
+			t.Fatalf("failed to read versions: %v", err)
+		}
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func tableExists(t *testing.T, db *wpgxtest.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	if err := db.GetPool().RawPrimaryPool().QueryRow(context.Background(),
+		"SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil {
+		t.Fatalf("failed to look up %s: %v", name, err)
+	}
+	return exists
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMigrator_UpTargetAndDown(t *testing.T) {
+	db := wpgxtest.New(t)
+
+	if err := migrateTo(t, db, &migrate.Config{Target: 2}); err != nil {
+		t.Fatalf("migrating to 2 failed: %v", err)
+	}
+	if vs := versions(t, db); !equal(vs, []int64{1, 2}) {
+		t.Errorf("Expected versions [1 2], got %v", vs)
+	}
+	if tableExists(t, db, "orders") {
+		t.Errorf("Expected orders not to be created above the target")
+	}
+
+	// applied migrations are found again, and not run twice.
+	if err := migrateTo(t, db, &migrate.Config{}); err != nil {
+		t.Fatalf("migrating to latest failed: %v", err)
+	}
+	if vs := versions(t, db); !equal(vs, []int64{1, 2, 3}) {
+		t.Errorf("Expected versions [1 2 3], got %v", vs)
+	}
+
+	// 3 has no down file.
+	if err := migrateTo(t, db, &migrate.Config{Down: true, Target: 1}); err == nil {
+		t.Errorf("Expected rolling back 3 to fail")
+	}
+	if _, err := db.GetPool().RawPrimaryPool().Exec(context.Background(),
+		`DELETE FROM "SchemaMigrations" WHERE version = 3`); err != nil {
+		t.Fatalf("failed to forget 3: %v", err)
+	}
+	if err := migrateTo(t, db, &migrate.Config{Down: true, Target: 1}); err != nil {
+		t.Fatalf("rolling back to 1 failed: %v", err)
+	}
+	if vs := versions(t, db); !equal(vs, []int64{1}) {
+		t.Errorf("Expected versions [1], got %v", vs)
+	}
+	var hasEmail bool
+	if err := db.GetPool().RawPrimaryPool().QueryRow(context.Background(),
+		"SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'email')").Scan(&hasEmail); err != nil {
+		t.Fatalf("failed to look up users.email: %v", err)
+	}
+	if hasEmail {
+		t.Errorf("Expected users.email to be dropped")
+	}
+}
+
+func TestMigrator_FailedMigrationIsRolledBack(t *testing.T) {
+	db := wpgxtest.New(t)
+	broken := fstest.MapFS{
+		"1_create_users.up.sql": {Data: []byte("CREATE TABLE users (id bigint);")},
+		"2_broken.up.sql":       {Data: []byte("CREATE TABLE pets (id bigint);\nSELECT * FROM missing;")},
+	}
+	err := migrate.NewMigrator(db.WPGX, broken, &migrate.Config{Table: table}).Init(context.Background())
+	if err == nil {
+		t.Fatal("Expected the broken migration to fail")
+	}
+	if vs := versions(t, db); !equal(vs, []int64{1}) {
+		t.Errorf("Expected versions [1], got %v", vs)
+	}
+	if tableExists(t, db, "pets") {
+		t.Errorf("Expected pets to be rolled back with its migration")
+	}
+}
+
+func TestMigrator_AsyncWaitsForLockAndGatesQueries(t *testing.T) {
+	db := wpgxtest.New(t)
+	ctx := context.Background()
+
+	conf := &migrate.Config{Table: table, Async: true}
+	m := migrate.NewMigrator(db.WPGX, migrations, conf)
+	// another replica is migrating.
+	holder, err := db.GetPool().RawPrimaryPool().Acquire(ctx)
+	if err != nil {
+		t.Fatalf("failed to acquire connection: %v", err)
+	}
+	if _, err = holder.Exec(ctx, "SELECT pg_advisory_lock($1)", conf.LockID); err != nil {
+		t.Fatalf("failed to take the lock: %v", err)
+	}
+
+	if err = m.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if err = m.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = m.Stop(ctx)
+	})
+	time.Sleep(100 * time.Millisecond)
+	if err = m.OK(ctx); err == nil {
+		t.Errorf("Expected OK to fail while the lock is held")
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err = db.WConn().WExec(queryCtx, "select_one", "SELECT 1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected queries to wait for the migrations, got %v", err)
+	}
+	if err = db.OK(ctx); err != nil {
+		t.Errorf("Expected wpgx OK to pass during migrations, got %v", err)
+	}
+	// waiting queries do not hold connections of the pool.
+	waiters := make(chan error, 5)
+	for range cap(waiters) {
+		go func() {
+			_, err := db.WConn().WExec(ctx, "select_users", "SELECT * FROM users")
+			waiters <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if acquired := db.GetPool().RawPrimaryPool().Stat().AcquiredConns(); acquired != 1 {
+		t.Errorf("Expected only the lock holder to hold a connection, got %d", acquired)
+	}
+
+	if _, err = holder.Exec(ctx, "SELECT pg_advisory_unlock($1)", conf.LockID); err != nil {
+		t.Fatalf("failed to release the lock: %v", err)
+	}
+	holder.Release()
+	waitCtx, cancelWait := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelWait()
+	if err = m.Wait(waitCtx); err != nil {
+		t.Fatalf("migrations failed: %v", err)
+	}
+	if err = m.OK(ctx); err != nil {
+		t.Errorf("Expected OK once migrated, got %v", err)
+	}
+	for range cap(waiters) {
+		if err = <-waiters; err != nil {
+			t.Errorf("Expected waiting queries to run once migrated, got %v", err)
+		}
+	}
+}
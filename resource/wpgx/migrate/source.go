@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// fileName matches <version>_<name>.up.sql and <version>_<name>.down.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+	hasDown bool
+}
+
+// loadMigrations reads the migrations at the root of fsys sorted by version.
+// Files not named like a migration are ignored.
+func loadMigrations(fsys fs.FS) ([]*migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	byVersion := map[int64]*migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.name, match[2])
+		}
+		b, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		if match[3] == "up" {
+			m.up = string(b)
+		} else {
+			m.down = string(b)
+			m.hasDown = true
+		}
+	}
+	migrations := make([]*migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.version, m.name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
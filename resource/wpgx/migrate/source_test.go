@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"2_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email text;")},
+		"2_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email;")},
+		"10_add_index.up.sql":     {Data: []byte("CREATE INDEX users_email ON users (email);")},
+		"1_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id bigint);")},
+		"README.md":               {Data: []byte("not a migration")},
+		"seeds/1_seed.up.sql":     {Data: []byte("ignored")},
+		"1_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	}
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	want := []int64{1, 2, 10}
+	if len(migrations) != len(want) {
+		t.Fatalf("Expected %d migrations, got %d", len(want), len(migrations))
+	}
+	for i, m := range migrations {
+		if m.version != want[i] {
+			t.Errorf("Expected migration %d to be version %d, got %d", i, want[i], m.version)
+		}
+	}
+	if migrations[2].hasDown {
+		t.Errorf("Expected 10_add_index to have no down file")
+	}
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"duplicate version": {
+			"1_a.up.sql": {Data: []byte("SELECT 1;")},
+			"1_b.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"down without up": {
+			"1_a.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+	for name, fsys := range tests {
+		if _, err := loadMigrations(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
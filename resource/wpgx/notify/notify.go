@@ -0,0 +1,289 @@
+// Package notify dispatches Postgres notifications (LISTEN/NOTIFY) to
+// handlers.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stumble/wpgx"
+
+	"github.com/ggsrc/gglib/interceptor/grpc/recovery"
+	gglibwpgx "github.com/ggsrc/gglib/resource/wpgx"
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+var notifications = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gglib_wpgx_notifications_total",
+		Help: "notifications handled by channel and result: {success, error}.",
+	}, []string{"channel", "result"})
+
+func init() {
+	prometheus.MustRegister(notifications)
+}
+
+// Notification is a notification received on a channel.
+type Notification struct {
+	Channel string
+	Payload string
+	// PID is the backend process that sent the notification.
+	PID uint32
+}
+
+// Handler handles a notification. Errors are logged, panics are recovered
+// and reported to the handler of WithPanicHandler, notifications are not
+// redelivered.
+type Handler func(ctx context.Context, n *Notification) error
+
+// HandleJSON returns a Handler decoding payloads as JSON into T.
+func HandleJSON[T any](fn func(ctx context.Context, channel string, v T) error) Handler {
+	return func(ctx context.Context, n *Notification) error {
+		var v T
+		if err := json.Unmarshal([]byte(n.Payload), &v); err != nil {
+			return fmt.Errorf("failed to decode payload on %s: %w", n.Channel, err)
+		}
+		return fn(ctx, n.Channel, v)
+	}
+}
+
+type Option func(l *Listener)
+
+// WithPanicHandler reports panics of handlers, with the channel as method.
+func WithPanicHandler(handler recovery.PanicHandler) Option {
+	return func(l *Listener) {
+		l.panicHandler = handler
+	}
+}
+
+// ErrInitialized is returned by Handle after Init: the channels are listened
+// on when connecting.
+var ErrInitialized = errors.New("notify: listener already initialized")
+
+type Config struct {
+	ReconnectDelay    time.Duration `default:"1s"` // doubled after each failed reconnection
+	MaxReconnectDelay time.Duration `default:"30s"`
+	ConnectTimeout    time.Duration `default:"10s"`
+}
+
+// Listener is a resource listening on the channels of the registered
+// handlers with a dedicated connection to the primary of a wpgx.Config.
+// The connection is re-established after it is lost, notifications sent
+// meanwhile are lost.
+type Listener struct {
+	pgConfig     *wpgx.Config
+	conf         *Config
+	panicHandler recovery.PanicHandler
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+
+	initialized bool
+	conn        *pgx.Conn
+	listening   atomic.Bool
+	lastErr     atomic.Pointer[error]
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func NewListenerWithDefaultEnvPrefix(opts ...Option) *Listener {
+	conf := &Config{}
+	envconfig.MustProcess("WPGX_NOTIFY", conf)
+	return NewListener(wpgx.ConfigFromEnvPrefix(wpgx.DefaultEnvPrefix), conf, opts...)
+}
+
+// NewListener creates a listener. Reconnection delays not set in conf
+// default to 1s and 30s.
+func NewListener(pgConfig *wpgx.Config, conf *Config, opts ...Option) *Listener {
+	if pgConfig == nil || conf == nil {
+		panic("pgConfig and conf cannot be nil")
+	}
+	if conf.ReconnectDelay <= 0 {
+		conf.ReconnectDelay = time.Second
+	}
+	if conf.MaxReconnectDelay <= 0 {
+		conf.MaxReconnectDelay = 30 * time.Second
+	}
+	conf.MaxReconnectDelay = max(conf.MaxReconnectDelay, conf.ReconnectDelay)
+	l := &Listener{
+		pgConfig: pgConfig,
+		conf:     conf,
+		handlers: map[string][]Handler{},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Handle registers h for notifications on channel. It fails with
+// ErrInitialized after Init.
+func (l *Listener) Handle(channel string, h Handler) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.initialized {
+		return fmt.Errorf("failed to handle %s: %w", channel, ErrInitialized)
+	}
+	l.handlers[channel] = append(l.handlers[channel], h)
+	return nil
+}
+
+func (l *Listener) Name() string {
+	return "wpgx_notify"
+}
+
+func (l *Listener) Init(ctx context.Context) error {
+	if err := l.connect(ctx); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.initialized = true
+	return nil
+}
+
+func (l *Listener) Start(ctx context.Context) error {
+	if !l.initialized {
+		return errors.New("listener not initialized")
+	}
+	// Start ctx is cancelled once every resource is started.
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		l.run(runCtx)
+	}()
+	return nil
+}
+
+func (l *Listener) Stop(ctx context.Context) error {
+	if l.cancel != nil {
+		l.cancel()
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if l.conn != nil {
+		return l.conn.Close(ctx)
+	}
+	return nil
+}
+
+// OK fails while the connection is lost.
+func (l *Listener) OK(ctx context.Context) error {
+	if l.listening.Load() {
+		return nil
+	}
+	if err := l.lastErr.Load(); err != nil {
+		return fmt.Errorf("not listening: %w", *err)
+	}
+	return errors.New("not listening")
+}
+
+// connect opens the connection, with the settings of the wpgx pools, and
+// listens on every channel.
+func (l *Listener) connect(ctx context.Context) error {
+	connConfig, err := gglibwpgx.ConnConfig(l.pgConfig)
+	if err != nil {
+		return err
+	}
+	connConfig.ConnectTimeout = l.conf.ConnectTimeout
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for channel := range l.handlers {
+		if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			_ = conn.Close(ctx)
+			return fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+	l.conn = conn
+	l.listening.Store(true)
+	l.lastErr.Store(nil)
+	return nil
+}
+
+func (l *Listener) run(ctx context.Context) {
+	for {
+		n, err := l.conn.WaitForNotification(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			l.listening.Store(false)
+			l.lastErr.Store(&err)
+			log.Ctx(ctx).Err(err).Msg("lost notification connection, reconnecting")
+			_ = l.conn.Close(context.WithoutCancel(ctx))
+			if !l.reconnect(ctx) {
+				return
+			}
+			continue
+		}
+		l.dispatch(ctx, &Notification{Channel: n.Channel, Payload: n.Payload, PID: n.PID})
+	}
+}
+
+// reconnect retries connect with backoff until it succeeds, returning false
+// if ctx is done first.
+func (l *Listener) reconnect(ctx context.Context) bool {
+	delay := l.conf.ReconnectDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		err := l.connect(ctx)
+		if err == nil {
+			log.Ctx(ctx).Info().Msg("notification connection restored")
+			return true
+		}
+		l.lastErr.Store(&err)
+		log.Ctx(ctx).Err(err).Msgf("failed to reconnect, retrying in %s", delay)
+		delay = min(delay*2, l.conf.MaxReconnectDelay)
+	}
+}
+
+func (l *Listener) dispatch(ctx context.Context, n *Notification) {
+	l.mu.RLock()
+	handlers := l.handlers[n.Channel]
+	l.mu.RUnlock()
+	for _, h := range handlers {
+		result := "success"
+		if err := l.handle(ctx, h, n); err != nil {
+			result = "error"
+			log.Ctx(ctx).Err(err).Msgf("failed to handle notification on %s", n.Channel)
+		}
+		notifications.WithLabelValues(n.Channel, result).Inc()
+	}
+}
+
+func (l *Listener) handle(ctx context.Context, h Handler, n *Notification) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			err = fmt.Errorf("[panic] %v", r)
+			log.Ctx(ctx).Error().Str("panic.stack", string(stack)).Err(err).Msgf("notification handler on %s panic", n.Channel)
+			if l.panicHandler != nil {
+				l.panicHandler(ctx, n.Channel, r, stack)
+			}
+		}
+	}()
+	return h(ctx, n)
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stumble/wpgx"
+
+	"github.com/ggsrc/gglib/resource/wpgx/wpgxtest"
+)
+
+type userEvent struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestHandleJSON(t *testing.T) {
+	var got userEvent
+	h := HandleJSON(func(ctx context.Context, channel string, v userEvent) error {
+		got = v
+		return nil
+	})
+	if err := h(context.Background(), &Notification{Channel: "users", Payload: `{"id":1,"name":"alice"}`}); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if got.ID != 1 || got.Name != "alice" {
+		t.Errorf("Unexpected payload %+v", got)
+	}
+	if err := h(context.Background(), &Notification{Channel: "users", Payload: "not json"}); err == nil {
+		t.Error("Expected a decoding error")
+	}
+}
+
+func TestNewListener_DefaultsReconnectDelays(t *testing.T) {
+	l := NewListener(&wpgx.Config{}, &Config{})
+	if l.conf.ReconnectDelay != time.Second || l.conf.MaxReconnectDelay != 30*time.Second {
+		t.Errorf("Expected delays of 1s up to 30s, got %s up to %s", l.conf.ReconnectDelay, l.conf.MaxReconnectDelay)
+	}
+
+	l = NewListener(&wpgx.Config{}, &Config{ReconnectDelay: time.Minute})
+	if l.conf.MaxReconnectDelay != time.Minute {
+		t.Errorf("Expected the max delay to be at least the delay, got %s", l.conf.MaxReconnectDelay)
+	}
+}
+
+func TestListener_HandleAfterInit(t *testing.T) {
+	l := NewListener(&wpgx.Config{}, &Config{})
+	if err := l.Handle("users", func(ctx context.Context, n *Notification) error { return nil }); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	l.initialized = true
+	err := l.Handle("orders", func(ctx context.Context, n *Notification) error { return nil })
+	if !errors.Is(err, ErrInitialized) {
+		t.Errorf("Expected ErrInitialized, got %v", err)
+	}
+	if _, ok := l.handlers["orders"]; ok {
+		t.Error("Expected orders not to be registered")
+	}
+}
+
+func TestListener_DispatchRecoversPanics(t *testing.T) {
+	l := NewListener(&wpgx.Config{}, &Config{})
+	var calls int
+	_ = l.Handle("users", func(ctx context.Context, n *Notification) error {
+		panic("boom")
+	})
+	_ = l.Handle("users", func(ctx context.Context, n *Notification) error {
+		calls++
+		return nil
+	})
+	l.dispatch(context.Background(), &Notification{Channel: "users"})
+	if calls != 1 {
+		t.Errorf("Expected the second handler to run after the first panicked, got %d calls", calls)
+	}
+}
+
+func TestListener_ReportsPanics(t *testing.T) {
+	var gotMethod string
+	var gotPanic any
+	l := NewListener(&wpgx.Config{}, &Config{}, WithPanicHandler(func(ctx context.Context, method string, r any, stack []byte) {
+		gotMethod, gotPanic = method, r
+	}))
+	_ = l.Handle("users", func(ctx context.Context, n *Notification) error {
+		panic("boom")
+	})
+	l.dispatch(context.Background(), &Notification{Channel: "users"})
+	if gotMethod != "users" || gotPanic != "boom" {
+		t.Errorf("Expected the panic on users to be reported, got %q on %q", gotPanic, gotMethod)
+	}
+}
+
+func TestListener_ReconnectsAndListensAgain(t *testing.T) {
+	db := wpgxtest.New(t)
+	ctx := context.Background()
+
+	received := make(chan string, 10)
+	l := NewListener(db.Config, &Config{
+		ReconnectDelay:    10 * time.Millisecond,
+		MaxReconnectDelay: 100 * time.Millisecond,
+		ConnectTimeout:    time.Second,
+	})
+	if err := l.Handle("users", func(ctx context.Context, n *Notification) error {
+		received <- n.Payload
+		return nil
+	}); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if err := l.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if err := l.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := l.Stop(ctx); err != nil {
+			t.Errorf("Stop failed: %v", err)
+		}
+	})
+
+	notify := func(payload string) {
+		t.Helper()
+		if _, err := db.WConn().WExec(ctx, "notify", "SELECT pg_notify('users', $1)", payload); err != nil {
+			t.Fatalf("failed to notify: %v", err)
+		}
+	}
+	expect := func(payload string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != payload {
+				t.Errorf("Expected %s, got %s", payload, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %s to be received", payload)
+		}
+	}
+	notify("before")
+	expect("before")
+
+	pid := l.conn.PgConn().PID()
+	if _, err := db.WConn().WExec(ctx, "terminate", "SELECT pg_terminate_backend($1)", pid); err != nil {
+		t.Fatalf("failed to terminate the listener: %v", err)
+	}
+	// the listener connection is the one which last ran LISTEN.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var relistened bool
+		err := db.WConn().WQueryRow(ctx, "relistened", `SELECT EXISTS (SELECT 1 FROM pg_stat_activity
+			WHERE datname = current_database() AND pid <> $1 AND query = 'LISTEN "users"')`, pid).Scan(&relistened)
+		if err != nil {
+			t.Fatalf("failed to look up the listener: %v", err)
+		}
+		if relistened && l.OK(ctx) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the listener to reconnect, got %v", l.OK(ctx))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	notify("after")
+	expect("after")
+}
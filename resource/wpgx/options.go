@@ -2,6 +2,7 @@ package wpgx
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/stumble/wpgx"
@@ -20,3 +21,37 @@ func WithBeforeAcquire(f func(context.Context, *pgx.Conn) bool) Options {
 		w.beforeAcquire = f
 	}
 }
+
+// WithTypeLoader makes WPGX load the types of p on Init, failing if one of
+// them is missing, and register them on every connection of the pool.
+func WithTypeLoader(p *TypeLoaderParam) Options {
+	return func(w *WPGX) {
+		w.loader = NewLoader(p)
+	}
+}
+
+// WithSlowQueryThreshold logs queries run through WPGX.WConn and
+// WPGX.WQuerier that take longer than threshold, rows iteration included.
+// Queries run in WithTx or on GetPool are not measured.
+func WithSlowQueryThreshold(threshold time.Duration) Options {
+	return func(w *WPGX) {
+		w.slowQueryThreshold = threshold
+	}
+}
+
+// WithReplicaCheck probes read replicas every interval, 10s if 0, and makes
+// WPGX.WQuerier route reads to the primary instead of replicas not
+// answering or lagging more than maxLag, 0 to ignore lag.
+//
+// It changes how replicas affect health: Init no longer fails on an
+// unreachable replica, and OK only pings the primary and logs unhealthy
+// replicas. Without it, both fail on an unreachable replica.
+func WithReplicaCheck(interval, maxLag time.Duration) Options {
+	return func(w *WPGX) {
+		if interval <= 0 {
+			interval = defaultReplicaCheckInterval
+		}
+		w.replicaCheckInterval = interval
+		w.replicaMaxLag = maxLag
+	}
+}
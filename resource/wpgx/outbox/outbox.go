@@ -0,0 +1,101 @@
+// Package outbox implements the transactional outbox pattern on wpgx: events
+// are inserted in the transaction of the change they describe, and a
+// Publisher resource delivers the committed ones to a Sink at least once.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stumble/wpgx"
+)
+
+const DefaultTable = "outbox"
+
+// Event is an event to publish once the transaction inserting it commits.
+type Event struct {
+	Topic string
+	// Key identifies the entity of the event, e.g. for partitioning by the sink.
+	Key string
+	// Payload is marshaled to JSON unless it is a json.RawMessage or []byte.
+	Payload any
+	Headers map[string]string
+}
+
+// Message is an event read back from the outbox table by the Publisher.
+type Message struct {
+	ID        int64
+	Topic     string
+	Key       string
+	Payload   json.RawMessage
+	Headers   map[string]string
+	CreatedAt time.Time
+	// Attempts is the number of failed deliveries before this one.
+	Attempts int
+}
+
+// Schema returns the DDL of the outbox table, to be added to migrations.
+func Schema(table string) string {
+	t := pgx.Identifier{table}.Sanitize()
+	idx := pgx.Identifier{table + "_pending"}.Sanitize()
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id bigserial PRIMARY KEY,
+	topic text NOT NULL,
+	key text NOT NULL DEFAULT '',
+	payload jsonb NOT NULL,
+	headers jsonb NOT NULL DEFAULT '{}',
+	created_at timestamptz NOT NULL DEFAULT now(),
+	attempts int NOT NULL DEFAULT 0,
+	next_attempt_at timestamptz NOT NULL DEFAULT now(),
+	last_error text,
+	delivered_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS %s ON %s (next_attempt_at, id) WHERE delivered_at IS NULL;
+`, t, idx, t)
+}
+
+// Insert adds events to the default outbox table, see InsertInto.
+func Insert(ctx context.Context, tx wpgx.WExecer, events ...*Event) error {
+	return InsertInto(ctx, tx, DefaultTable, events...)
+}
+
+// InsertInto adds events to table with tx, which should be the transaction
+// of the change the events describe. It notifies the Publisher listening on
+// table, the notification is only delivered on commit.
+func InsertInto(ctx context.Context, tx wpgx.WExecer, table string, events ...*Event) error {
+	query := fmt.Sprintf(
+		"INSERT INTO %s (topic, key, payload, headers) VALUES ($1, $2, $3, $4)", pgx.Identifier{table}.Sanitize())
+	for _, e := range events {
+		payload, err := marshalPayload(e.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload of %s event: %w", e.Topic, err)
+		}
+		headers := e.Headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		if _, err = tx.WExec(ctx, "outbox_insert", query, e.Topic, e.Key, payload, headers); err != nil {
+			return fmt.Errorf("failed to insert %s event: %w", e.Topic, err)
+		}
+	}
+	if len(events) > 0 {
+		if _, err := tx.WExec(ctx, "outbox_notify", "SELECT pg_notify($1, '')", table); err != nil {
+			return fmt.Errorf("failed to notify outbox publisher: %w", err)
+		}
+	}
+	return nil
+}
+
+func marshalPayload(payload any) (json.RawMessage, error) {
+	switch p := payload.(type) {
+	case json.RawMessage:
+		return p, nil
+	case []byte:
+		return p, nil
+	default:
+		return json.Marshal(p)
+	}
+}
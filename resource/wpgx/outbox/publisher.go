@@ -0,0 +1,361 @@
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	stumblewpgx "github.com/stumble/wpgx"
+
+	"github.com/ggsrc/gglib/resource/wpgx"
+	"github.com/ggsrc/gglib/zerolog/log"
+)
+
+const listenRetryDelay = time.Second
+
+var (
+	publishedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gglib_outbox_published_total",
+			Help: "outbox deliveries by result: {success, error}.",
+		}, []string{"table", "topic", "result"})
+	backlogMessages = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gglib_outbox_backlog",
+			Help: "undelivered messages which are still retried.",
+		}, []string{"table"})
+	backlogAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gglib_outbox_backlog_age_seconds",
+			Help: "age of the oldest undelivered message which is still retried.",
+		}, []string{"table"})
+	deadMessages = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gglib_outbox_dead",
+			Help: "undelivered messages which reached MaxAttempts and are no longer retried.",
+		}, []string{"table"})
+)
+
+func init() {
+	prometheus.MustRegister(publishedMessages, backlogMessages, backlogAge, deadMessages)
+}
+
+type Config struct {
+	Table        string        `default:"outbox"`
+	BatchSize    int           `default:"100"`
+	PollInterval time.Duration `default:"1s"`
+	// Listen wakes the publisher up on notifications from InsertInto instead
+	// of waiting for the next poll.
+	Listen         bool          `default:"true"`
+	PublishTimeout time.Duration `default:"10s"`
+	// Lease is how long claimed messages are reserved for the publisher that
+	// claimed them. The messages of a batch not published within it are left
+	// for the next claim, and a publisher dying mid-batch delays its messages
+	// by up to Lease.
+	Lease time.Duration `default:"1m"`
+	// MaxAttempts is the number of deliveries of a message before it is given
+	// up on, 0 retries forever.
+	MaxAttempts int           `default:"10"`
+	Backoff     time.Duration `default:"1s"` // doubled after each failed delivery
+	MaxBackoff  time.Duration `default:"5m"`
+	// DeleteDelivered deletes delivered messages instead of setting delivered_at.
+	DeleteDelivered bool `default:"false"`
+}
+
+// Publisher is a resource delivering the messages of Config.Table to a Sink
+// in ID order. Messages are claimed with FOR UPDATE SKIP LOCKED and leased
+// for Config.Lease, so several replicas can run publishers on the same table. A failed message is retried
+// with exponential backoff without blocking the following ones.
+type Publisher struct {
+	wpgx *wpgx.WPGX
+	sink Sink
+	conf *Config
+
+	initialized bool
+	cancel      context.CancelFunc
+	done        chan struct{}
+	wake        chan struct{}
+}
+
+func NewPublisherWithEnvPrefix(w *wpgx.WPGX, sink Sink, envPrefix string) *Publisher {
+	conf := &Config{}
+	envconfig.MustProcess(envPrefix, conf)
+	return NewPublisher(w, sink, conf)
+}
+
+func NewPublisher(w *wpgx.WPGX, sink Sink, conf *Config) *Publisher {
+	if w == nil || sink == nil || conf == nil {
+		panic("wpgx, sink and conf cannot be nil")
+	}
+	// a hand-built Config gets the defaults of its env tags.
+	conf.Table = cmp.Or(conf.Table, DefaultTable)
+	conf.BatchSize = max(conf.BatchSize, 1)
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = time.Second
+	}
+	if conf.PublishTimeout <= 0 {
+		conf.PublishTimeout = 10 * time.Second
+	}
+	if conf.Lease <= 0 {
+		conf.Lease = time.Minute
+	}
+	if conf.Backoff <= 0 {
+		conf.Backoff = time.Second
+	}
+	if conf.MaxBackoff <= 0 {
+		conf.MaxBackoff = 5 * time.Minute
+	}
+	conf.MaxBackoff = max(conf.MaxBackoff, conf.Backoff)
+	return &Publisher{
+		wpgx: w,
+		sink: sink,
+		conf: conf,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func (p *Publisher) Name() string {
+	return "outbox_publisher_" + p.conf.Table
+}
+
+func (p *Publisher) Init(ctx context.Context) error {
+	if p.wpgx.GetPool() == nil {
+		return errors.New("wpgx must be initialized before outbox publisher")
+	}
+	p.initialized = true
+	return nil
+}
+
+func (p *Publisher) Start(ctx context.Context) error {
+	if !p.initialized {
+		return errors.New("outbox publisher not initialized")
+	}
+	// Start ctx is cancelled once every resource is started.
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	if p.conf.Listen {
+		go p.listen(runCtx)
+	}
+	go func() {
+		defer close(p.done)
+		p.run(runCtx)
+	}()
+	return nil
+}
+
+// Stop waits for the message being published and the batch to be recorded,
+// until ctx is done.
+func (p *Publisher) Stop(ctx context.Context) error {
+	if p.cancel == nil {
+		return nil
+	}
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) OK(ctx context.Context) error {
+	return nil
+}
+
+func (p *Publisher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.conf.PollInterval)
+	defer ticker.Stop()
+	for {
+		n, err := p.publishBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Ctx(ctx).Err(err).Msgf("failed to publish outbox %s", p.conf.Table)
+		}
+		// keep draining full batches.
+		if err == nil && n == p.conf.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-ticker.C:
+			p.updateBacklog(ctx)
+		}
+	}
+}
+
+// publishBatch publishes the next batch of due messages and returns how many
+// were claimed. Messages are claimed with a lease in a statement of their
+// own and published outside of any transaction, so that no row lock or
+// connection is held while the sink is called.
+func (p *Publisher) publishBatch(ctx context.Context) (int, error) {
+	msgs, err := p.claim(ctx)
+	if err != nil || len(msgs) == 0 {
+		return len(msgs), err
+	}
+	leaseEnd := time.Now().Add(p.conf.Lease)
+
+	var delivered []int64
+	failed := map[int64]error{}
+	for _, m := range msgs {
+		// the others are claimed again when their lease expires.
+		if ctx.Err() != nil || time.Now().After(leaseEnd) {
+			break
+		}
+		if err = p.publish(ctx, m); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("failed to publish outbox message %d to %s, attempt %d", m.ID, m.Topic, m.Attempts+1)
+			failed[m.ID] = err
+			continue
+		}
+		delivered = append(delivered, m.ID)
+	}
+	// published messages are recorded even if the publisher is stopping.
+	return len(msgs), p.record(context.WithoutCancel(ctx), msgs, delivered, failed)
+}
+
+// claim leases the next batch of due messages by moving their
+// next_attempt_at past the lease, in ID order.
+func (p *Publisher) claim(ctx context.Context) ([]*Message, error) {
+	table := pgx.Identifier{p.conf.Table}.Sanitize()
+	rows, err := p.wpgx.WConn().WQuery(ctx, "outbox_claim", fmt.Sprintf(`UPDATE %s
+		SET next_attempt_at = now() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM %s
+			WHERE delivered_at IS NULL AND next_attempt_at <= now() AND ($2 = 0 OR attempts < $2)
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, topic, key, payload, headers, created_at, attempts`, table, table),
+		p.conf.BatchSize, p.conf.MaxAttempts, p.conf.Lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Message, error) {
+		m := &Message{}
+		err := row.Scan(&m.ID, &m.Topic, &m.Key, &m.Payload, &m.Headers, &m.CreatedAt, &m.Attempts)
+		return m, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(msgs, func(a, b *Message) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return msgs, nil
+}
+
+// record marks delivered messages as such and schedules the retry of failed
+// ones. Messages in neither are left to their lease.
+func (p *Publisher) record(ctx context.Context, msgs []*Message, delivered []int64, failed map[int64]error) error {
+	if len(delivered) == 0 && len(failed) == 0 {
+		return nil
+	}
+	table := pgx.Identifier{p.conf.Table}.Sanitize()
+	return p.wpgx.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+		for _, m := range msgs {
+			pubErr, ok := failed[m.ID]
+			if !ok {
+				continue
+			}
+			_, err := tx.WExec(ctx, "outbox_fail", fmt.Sprintf(`UPDATE %s
+				SET attempts = attempts + 1, next_attempt_at = now() + make_interval(secs => $2), last_error = $3
+				WHERE id = $1 AND delivered_at IS NULL`, table), m.ID, p.backoff(m.Attempts+1).Seconds(), pubErr.Error())
+			if err != nil {
+				return err
+			}
+		}
+		if len(delivered) == 0 {
+			return nil
+		}
+		query := fmt.Sprintf("UPDATE %s SET delivered_at = now() WHERE id = ANY($1)", table)
+		if p.conf.DeleteDelivered {
+			query = fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)", table)
+		}
+		_, err := tx.WExec(ctx, "outbox_delivered", query, delivered)
+		return err
+	})
+}
+
+func (p *Publisher) publish(ctx context.Context, m *Message) error {
+	ctx, cancel := context.WithTimeout(ctx, p.conf.PublishTimeout)
+	defer cancel()
+	err := p.sink.Publish(ctx, m)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	publishedMessages.WithLabelValues(p.conf.Table, m.Topic, result).Inc()
+	return err
+}
+
+// backoff returns the delay before the next delivery after attempts failed.
+func (p *Publisher) backoff(attempts int) time.Duration {
+	d := p.conf.Backoff
+	for i := 1; i < attempts && d < p.conf.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.conf.MaxBackoff)
+}
+
+func (p *Publisher) updateBacklog(ctx context.Context) {
+	var backlog, dead int64
+	var age float64
+	err := p.wpgx.WConn().WQueryRow(ctx, "outbox_backlog", fmt.Sprintf(`SELECT
+			count(*) FILTER (WHERE $1 = 0 OR attempts < $1),
+			COALESCE(EXTRACT(EPOCH FROM now() - min(created_at) FILTER (WHERE $1 = 0 OR attempts < $1)), 0)::float8,
+			count(*) FILTER (WHERE $1 > 0 AND attempts >= $1)
+		FROM %s WHERE delivered_at IS NULL`, pgx.Identifier{p.conf.Table}.Sanitize()), p.conf.MaxAttempts).
+		Scan(&backlog, &age, &dead)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Ctx(ctx).Err(err).Msgf("failed to measure outbox %s backlog", p.conf.Table)
+		}
+		return
+	}
+	backlogMessages.WithLabelValues(p.conf.Table).Set(float64(backlog))
+	backlogAge.WithLabelValues(p.conf.Table).Set(age)
+	deadMessages.WithLabelValues(p.conf.Table).Set(float64(dead))
+}
+
+// listen wakes run up on notifications from InsertInto, reconnecting until
+// ctx is done.
+func (p *Publisher) listen(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := p.listenOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Ctx(ctx).Err(err).Msgf("failed to listen on outbox %s, polling only", p.conf.Table)
+			select {
+			case <-ctx.Done():
+			case <-time.After(listenRetryDelay):
+			}
+		}
+	}
+}
+
+func (p *Publisher) listenOnce(ctx context.Context) error {
+	conn, err := p.wpgx.GetPool().RawPrimaryPool().Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// the connection is in LISTEN state, do not give it back to the pool.
+	raw := conn.Hijack()
+	defer raw.Close(context.WithoutCancel(ctx))
+	if _, err = raw.Exec(ctx, "LISTEN "+pgx.Identifier{p.conf.Table}.Sanitize()); err != nil {
+		return err
+	}
+	for {
+		if _, err = raw.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
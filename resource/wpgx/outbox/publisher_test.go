@@ -0,0 +1,251 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	stumblewpgx "github.com/stumble/wpgx"
+
+	"github.com/ggsrc/gglib/resource/wpgx"
+	"github.com/ggsrc/gglib/resource/wpgx/wpgxtest"
+)
+
+var migrations = fstest.MapFS{
+	"1_create_outbox.up.sql": {Data: []byte(Schema(DefaultTable))},
+}
+
+type recordingSink struct {
+	mu   sync.Mutex
+	fail map[string]error
+	msgs []*Message
+}
+
+func (s *recordingSink) Publish(ctx context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail[msg.Topic]; err != nil {
+		return err
+	}
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func (s *recordingSink) topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics := make([]string, len(s.msgs))
+	for i, m := range s.msgs {
+		topics[i] = m.Topic
+	}
+	return topics
+}
+
+type row struct {
+	attempts    int
+	lastError   *string
+	delivered   bool
+	retryIn     time.Duration
+	stillExists bool
+}
+
+func newTestPublisher(t *testing.T, sink Sink, conf *Config) (*Publisher, *wpgxtest.DB) {
+	t.Helper()
+	db := wpgxtest.New(t, wpgxtest.WithMigrations(migrations))
+	conf.Table = DefaultTable
+	p := NewPublisher(db.WPGX, sink, conf)
+	if err := p.Init(context.Background()); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return p, db
+}
+
+func insert(t *testing.T, db *wpgxtest.DB, events ...*Event) {
+	t.Helper()
+	err := db.WithTx(context.Background(), nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+		return Insert(ctx, tx, events...)
+	})
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+}
+
+func getRow(t *testing.T, db *wpgxtest.DB, topic string) row {
+	t.Helper()
+	r := row{}
+	var retryIn float64
+	err := db.WConn().WQueryRow(context.Background(), "get_outbox", `SELECT attempts, last_error, delivered_at IS NOT NULL,
+		EXTRACT(EPOCH FROM next_attempt_at - now())::float8
+		FROM outbox WHERE topic = $1`, topic).Scan(&r.attempts, &r.lastError, &r.delivered, &retryIn)
+	if err != nil {
+		return r
+	}
+	r.stillExists = true
+	r.retryIn = time.Duration(retryIn * float64(time.Second))
+	return r
+}
+
+func TestNewPublisher_Defaults(t *testing.T) {
+	p := NewPublisher(&wpgx.WPGX{}, &recordingSink{}, &Config{Backoff: time.Hour})
+	want := Config{
+		Table:          DefaultTable,
+		BatchSize:      1,
+		PollInterval:   time.Second,
+		PublishTimeout: 10 * time.Second,
+		Lease:          time.Minute,
+		Backoff:        time.Hour,
+		MaxBackoff:     time.Hour,
+	}
+	if *p.conf != want {
+		t.Errorf("Expected %+v, got %+v", want, *p.conf)
+	}
+}
+
+func TestInsertInto(t *testing.T) {
+	db := wpgxtest.New(t, wpgxtest.WithMigrations(migrations))
+	ctx := context.Background()
+
+	insert(t, db, &Event{Topic: "user.created", Key: "1", Payload: map[string]int{"id": 1}, Headers: map[string]string{"tenant": "a"}})
+	errRollback := errors.New("rollback")
+	err := db.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+		if err := Insert(ctx, tx, &Event{Topic: "user.deleted"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Expected the rollback error, got %v", err)
+	}
+
+	var (
+		key     string
+		payload string
+		headers map[string]string
+		count   int
+	)
+	if err = db.WConn().WQueryRow(ctx, "count_outbox", "SELECT count(*) FROM outbox").Scan(&count); err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected the rolled back event not to be inserted, got %d rows", count)
+	}
+	err = db.WConn().WQueryRow(ctx, "get_outbox", "SELECT key, payload::text, headers FROM outbox WHERE topic = 'user.created'").
+		Scan(&key, &payload, &headers)
+	if err != nil {
+		t.Fatalf("failed to read event: %v", err)
+	}
+	if key != "1" || payload != `{"id": 1}` || headers["tenant"] != "a" {
+		t.Errorf("Unexpected event key=%s payload=%s headers=%v", key, payload, headers)
+	}
+}
+
+func TestPublisher_PublishesInOrderAndRetriesFailures(t *testing.T) {
+	errDown := errors.New("sink down")
+	sink := &recordingSink{fail: map[string]error{"b": errDown}}
+	p, db := newTestPublisher(t, sink, &Config{
+		BatchSize: 10, PublishTimeout: time.Second, MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour,
+	})
+	ctx := context.Background()
+	insert(t, db, &Event{Topic: "a"}, &Event{Topic: "b"}, &Event{Topic: "c"})
+
+	n, err := p.publishBatch(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 messages claimed, got %d, %v", n, err)
+	}
+	if topics := sink.topics(); len(topics) != 2 || topics[0] != "a" || topics[1] != "c" {
+		t.Errorf("Expected a and c published in order, got %v", topics)
+	}
+	if r := getRow(t, db, "a"); !r.delivered || r.attempts != 0 {
+		t.Errorf("Expected a to be delivered, got %+v", r)
+	}
+	b := getRow(t, db, "b")
+	if b.delivered || b.attempts != 1 || b.lastError == nil || *b.lastError != errDown.Error() {
+		t.Errorf("Expected b to have failed once, got %+v", b)
+	}
+	if b.retryIn < 50*time.Second || b.retryIn > time.Minute {
+		t.Errorf("Expected b to be retried in about a minute, got %s", b.retryIn)
+	}
+
+	// b is not due yet.
+	if n, err = p.publishBatch(ctx); err != nil || n != 0 {
+		t.Errorf("Expected nothing to claim, got %d, %v", n, err)
+	}
+}
+
+func TestPublisher_StopsAtMaxAttempts(t *testing.T) {
+	sink := &recordingSink{fail: map[string]error{"a": errors.New("sink down")}}
+	p, db := newTestPublisher(t, sink, &Config{
+		BatchSize: 10, PublishTimeout: time.Second, MaxAttempts: 2, Backoff: time.Millisecond, MaxBackoff: time.Millisecond,
+	})
+	ctx := context.Background()
+	insert(t, db, &Event{Topic: "a"})
+
+	for i := range 2 {
+		time.Sleep(10 * time.Millisecond)
+		if n, err := p.publishBatch(ctx); err != nil || n != 1 {
+			t.Fatalf("Expected attempt %d to claim a, got %d, %v", i+1, n, err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n, err := p.publishBatch(ctx); err != nil || n != 0 {
+		t.Errorf("Expected a to be given up on after 2 attempts, got %d, %v", n, err)
+	}
+	if r := getRow(t, db, "a"); r.attempts != 2 || r.delivered {
+		t.Errorf("Expected a to be undelivered after 2 attempts, got %+v", r)
+	}
+}
+
+func TestPublisher_DeleteDelivered(t *testing.T) {
+	p, db := newTestPublisher(t, &recordingSink{}, &Config{
+		BatchSize: 10, PublishTimeout: time.Second, DeleteDelivered: true,
+	})
+	insert(t, db, &Event{Topic: "a"})
+	if _, err := p.publishBatch(context.Background()); err != nil {
+		t.Fatalf("publishBatch failed: %v", err)
+	}
+	if r := getRow(t, db, "a"); r.stillExists {
+		t.Errorf("Expected a to be deleted once delivered, got %+v", r)
+	}
+}
+
+func TestPublisher_ClaimSkipsLockedAndLeased(t *testing.T) {
+	p, db := newTestPublisher(t, &recordingSink{}, &Config{
+		BatchSize: 10, PublishTimeout: time.Second, Lease: time.Minute,
+	})
+	ctx := context.Background()
+	insert(t, db, &Event{Topic: "locked"}, &Event{Topic: "free"})
+
+	// another publisher is claiming locked.
+	tx, err := db.GetPool().RawPrimaryPool().Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	if _, err = tx.Exec(ctx, "SELECT id FROM outbox WHERE topic = 'locked' FOR UPDATE"); err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+
+	msgs, err := p.claim(ctx)
+	if err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Topic != "free" {
+		t.Fatalf("Expected only free to be claimed, got %+v", msgs)
+	}
+	if r := getRow(t, db, "free"); r.retryIn < 50*time.Second {
+		t.Errorf("Expected free to be leased for a minute, got %s", r.retryIn)
+	}
+	if err = tx.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	// free is leased, locked is now due.
+	if msgs, err = p.claim(ctx); err != nil || len(msgs) != 1 || msgs[0].Topic != "locked" {
+		t.Errorf("Expected only locked to be claimed, got %+v, %v", msgs, err)
+	}
+}
@@ -0,0 +1,103 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sink delivers messages. Delivery is at least once: a message may be
+// published again if marking it delivered fails, so consumers must dedupe
+// by Message.ID if needed.
+type Sink interface {
+	Publish(ctx context.Context, msg *Message) error
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(ctx context.Context, msg *Message) error
+
+func (f SinkFunc) Publish(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// RedisStreamSink adds messages to the Redis stream named after their
+// topic, prefixed by Prefix.
+type RedisStreamSink struct {
+	Client redis.UniversalClient
+	Prefix string
+	// MaxLen approximately caps the streams, 0 for no cap.
+	MaxLen int64
+}
+
+func (s *RedisStreamSink) Publish(ctx context.Context, msg *Message) error {
+	headers, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return err
+	}
+	return s.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.Prefix + msg.Topic,
+		MaxLen: s.MaxLen,
+		Approx: s.MaxLen > 0,
+		Values: map[string]any{
+			"id":      msg.ID,
+			"key":     msg.Key,
+			"payload": []byte(msg.Payload),
+			"headers": headers,
+		},
+	}).Err()
+}
+
+// HatchetSink pushes messages as Hatchet events keyed by topic. Push is
+// usually the Push method of the Hatchet events client:
+//
+//	outbox.HatchetSink(func(ctx context.Context, key string, payload any) error {
+//		return h.GetClient().Events().Push(ctx, key, payload)
+//	})
+func HatchetSink(push func(ctx context.Context, eventKey string, payload any) error) Sink {
+	return SinkFunc(func(ctx context.Context, msg *Message) error {
+		return push(ctx, msg.Topic, msg.Payload)
+	})
+}
+
+// WebhookSink posts the payload of messages to URL. Topic, key and ID are
+// sent as X-Outbox-* headers along with the headers of the message. Any
+// status other than 2xx fails the delivery.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, msg *Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range msg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Outbox-Topic", msg.Topic)
+	req.Header.Set("X-Outbox-Key", msg.Key)
+	req.Header.Set("X-Outbox-Id", strconv.FormatInt(msg.ID, 10))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", s.URL, resp.Status)
+	}
+	return nil
+}
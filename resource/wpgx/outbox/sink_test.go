@@ -0,0 +1,54 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink_Publish(t *testing.T) {
+	var gotBody, gotTopic, gotID, gotTenant string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotTopic = r.Header.Get("X-Outbox-Topic")
+		gotID = r.Header.Get("X-Outbox-Id")
+		gotTenant = r.Header.Get("X-Tenant")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := &WebhookSink{URL: srv.URL}
+	msg := &Message{
+		ID:      7,
+		Topic:   "user.created",
+		Payload: json.RawMessage(`{"id":1}`),
+		Headers: map[string]string{"X-Tenant": "acme"},
+	}
+	if err := sink.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if gotBody != `{"id":1}` || gotTopic != "user.created" || gotID != "7" || gotTenant != "acme" {
+		t.Errorf("Unexpected request: body=%s topic=%s id=%s tenant=%s", gotBody, gotTopic, gotID, gotTenant)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := sink.Publish(context.Background(), msg); err == nil {
+		t.Error("Expected an error on 503")
+	}
+}
+
+func TestPublisher_Backoff(t *testing.T) {
+	p := &Publisher{conf: &Config{Backoff: time.Second, MaxBackoff: 5 * time.Second}}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
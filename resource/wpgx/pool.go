@@ -2,7 +2,11 @@ package wpgx
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 	"github.com/stumble/wpgx"
 )
@@ -12,20 +16,80 @@ func (w *WPGX) newWPGXPool(ctx context.Context) (*wpgx.Pool, error) {
 	if w.beforeAcquire != nil {
 		c.BeforeAcquire = w.beforeAcquire
 	}
+	if w.loader != nil {
+		// replicas need the types too.
+		c.BeforeAcquire = w.withTypes(c.BeforeAcquire)
+		for i := range c.ReadReplicas {
+			c.ReadReplicas[i].BeforeAcquire = w.withTypes(c.ReadReplicas[i].BeforeAcquire)
+		}
+	}
 	log.Ctx(ctx).Warn().Msgf("WPGX Config: %+v", c)
 	pool, err := wpgx.NewPool(ctx, c)
 	if err != nil {
 		return nil, err
 	}
-	if err = pool.Ping(ctx); err != nil {
+	// replicas are pinged below, they may not fail Init.
+	if err = pool.PingPrimary(ctx); err != nil {
+		pool.Close()
 		return nil, err
 	}
+	if w.loader != nil {
+		err = pool.RawPrimaryPool().AcquireFunc(ctx, func(conn *pgxpool.Conn) error {
+			return w.loader.Preload(ctx, conn.Conn())
+		})
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		log.Ctx(ctx).Info().Msgf("registered types: %v", w.loader.Types())
+	}
 	log.Ctx(ctx).Warn().Msg("primary pool is ready")
 	for name, readPool := range pool.ReplicaPools() {
 		if err = readPool.Ping(ctx); err != nil {
-			return nil, err
+			if w.replicaCheckInterval <= 0 {
+				pool.Close()
+				return nil, err
+			}
+			// routed to the primary until it recovers.
+			log.Ctx(ctx).Error().Err(err).Msgf("Read replica %s is not ready", name)
+			continue
 		}
 		log.Ctx(ctx).Warn().Msgf("Read replica %s is ready", name)
 	}
 	return pool, nil
 }
+
+// ConnConfig returns the connection settings of the primary of c, derived
+// like wpgx.NewPool does: from a URL with sslmode=disable, other settings
+// falling back to the PG* env variables.
+func ConnConfig(c *wpgx.Config) (*pgx.ConnConfig, error) {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:     "/" + c.DBName,
+		RawQuery: "sslmode=disable",
+	}
+	return pgx.ParseConfig(u.String())
+}
+
+// Connect opens a connection to the primary outside of the pools, closed by
+// the caller. It is not gated, see Gate.
+func (w *WPGX) Connect(ctx context.Context) (*pgx.Conn, error) {
+	connConfig, err := ConnConfig(w.config)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.ConnectConfig(ctx, connConfig)
+}
+
+// withTypes registers the types of the loader before calling next.
+func (w *WPGX) withTypes(next func(context.Context, *pgx.Conn) bool) func(context.Context, *pgx.Conn) bool {
+	return func(ctx context.Context, conn *pgx.Conn) bool {
+		w.loader.LoadTypes(ctx, conn)
+		if next != nil {
+			return next(ctx, conn)
+		}
+		return true
+	}
+}
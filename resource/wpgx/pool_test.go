@@ -0,0 +1,131 @@
+package wpgx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+	stumblewpgx "github.com/stumble/wpgx"
+
+	"github.com/ggsrc/gglib/resource/wpgx"
+	"github.com/ggsrc/gglib/resource/wpgx/wpgxtest"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWithSlowQueryThreshold_LogsWConnAndWQuerier(t *testing.T) {
+	db := wpgxtest.New(t,
+		wpgxtest.WithMigrations(itemsMigrations),
+		wpgxtest.WithWPGXOptions(wpgx.WithSlowQueryThreshold(time.Nanosecond)),
+	)
+	var buf syncBuffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+
+	if _, err := db.WConn().WExec(ctx, "InsertItem", "INSERT INTO items (id) VALUES (1)"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	q, err := db.WQuerier(nil)
+	if err != nil {
+		t.Fatalf("WQuerier failed: %v", err)
+	}
+	rows, err := q.WQuery(ctx, "ListItems", "SELECT id FROM items")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if _, err = pgx.CollectRows(rows, pgx.RowTo[int64]); err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	var count int
+	if err = q.WQueryRow(ctx, "CountItems", "SELECT count(*) FROM items").Scan(&count); err != nil {
+		t.Fatalf("failed to count items: %v", err)
+	}
+
+	out := buf.String()
+	for _, name := range []string{"InsertItem", "ListItems", "CountItems"} {
+		if strings.Count(out, `"query":"`+name+`"`) != 1 {
+			t.Errorf("Expected %s to be logged once as slow, got %s", name, out)
+		}
+	}
+}
+
+func TestWithReplicaCheck_RoutesAroundUnhealthyReplicas(t *testing.T) {
+	healthy, gone := stumblewpgx.ReplicaName("healthy"), stumblewpgx.ReplicaName("gone")
+	db := wpgxtest.New(t,
+		wpgxtest.WithReadReplicas(
+			stumblewpgx.ReadReplicaConfig{Name: healthy},
+			stumblewpgx.ReadReplicaConfig{Name: gone, DBName: "wpgxtest_missing"},
+		),
+		wpgxtest.WithWPGXOptions(wpgx.WithReplicaCheck(time.Hour, 0)),
+	)
+	ctx := context.Background()
+
+	statuses := db.ReplicaStatuses()
+	if len(statuses) != 2 || statuses[0].Name != gone || statuses[0].Healthy || !statuses[1].Healthy {
+		t.Fatalf("Expected gone to be unhealthy and healthy healthy, got %+v", statuses)
+	}
+
+	for _, name := range []stumblewpgx.ReplicaName{healthy, gone} {
+		q, err := db.WQuerier(&name)
+		if err != nil {
+			t.Fatalf("WQuerier(%s) failed: %v", name, err)
+		}
+		var one int
+		if err = q.WQueryRow(ctx, "select_one", "SELECT 1").Scan(&one); err != nil {
+			t.Errorf("Expected reads on %s to succeed, got %v", name, err)
+		}
+	}
+	q, err := db.GetPool().WQuerier(&gone)
+	if err != nil {
+		t.Fatalf("WQuerier(%s) failed: %v", gone, err)
+	}
+	var one int
+	if err = q.WQueryRow(ctx, "select_one", "SELECT 1").Scan(&one); err == nil {
+		t.Errorf("Expected the raw pool not to be routed")
+	}
+
+	var replicaErr *wpgx.ReplicaError
+	if err = db.OK(ctx); !errors.As(err, &replicaErr) || !replicaErr.NonFatal() {
+		t.Fatalf("Expected OK to report the unhealthy replica as non-fatal, got %v", err)
+	}
+	if len(replicaErr.Statuses) != 2 || !strings.Contains(err.Error(), "gone: ") || strings.Contains(err.Error(), "healthy: ") {
+		t.Errorf("Expected every status and only gone as unhealthy, got %+v: %v", replicaErr.Statuses, err)
+	}
+}
+
+func TestConnConfig(t *testing.T) {
+	t.Setenv("PGSSLMODE", "require")
+	t.Setenv("PGHOST", "elsewhere")
+	c, err := wpgx.ConnConfig(&stumblewpgx.Config{
+		Username: "app", Password: "p@ss:word", Host: "db.internal", Port: 5433, DBName: "orders",
+	})
+	if err != nil {
+		t.Fatalf("ConnConfig failed: %v", err)
+	}
+	if c.Host != "db.internal" || c.Port != 5433 || c.User != "app" || c.Password != "p@ss:word" || c.Database != "orders" {
+		t.Errorf("Unexpected settings %s@%s:%d/%s", c.User, c.Host, c.Port, c.Database)
+	}
+	if c.TLSConfig != nil || len(c.Fallbacks) != 0 {
+		t.Errorf("Expected sslmode=disable to override PGSSLMODE")
+	}
+}
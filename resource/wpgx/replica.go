@@ -0,0 +1,200 @@
+package wpgx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"github.com/stumble/wpgx"
+)
+
+const defaultReplicaCheckInterval = 10 * time.Second
+
+// replicaLagSQL returns 0 when the replica has replayed all the WAL it
+// received, so that an idle primary does not look like lag.
+const replicaLagSQL = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END::float8`
+
+var (
+	replicaHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gglib_wpgx_replica_healthy",
+			Help: "1 if the replica answers and lags less than the max lag, 0 otherwise.",
+		}, []string{"app", "replica"})
+	replicaLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gglib_wpgx_replica_lag_seconds",
+			Help: "replication lag of the replica measured by the last probe.",
+		}, []string{"app", "replica"})
+)
+
+func init() {
+	prometheus.MustRegister(replicaHealthy, replicaLag)
+}
+
+// ReplicaStatus is the result of the last probe of a read replica.
+type ReplicaStatus struct {
+	Name      wpgx.ReplicaName `json:"name"`
+	Healthy   bool             `json:"healthy"`
+	Lag       time.Duration    `json:"lag"`
+	Error     string           `json:"error,omitempty"`
+	CheckedAt time.Time        `json:"checked_at"`
+}
+
+// ReplicaError is returned by OK when read replicas are unhealthy. It is
+// non-fatal: reads are routed to the primary meanwhile, so ResourceManager
+// and health checks report it without failing.
+type ReplicaError struct {
+	// Statuses of every read replica, healthy or not.
+	Statuses []ReplicaStatus
+}
+
+func (e *ReplicaError) Error() string {
+	var unhealthy []string
+	for _, status := range e.Statuses {
+		if !status.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", status.Name, status.Error))
+		}
+	}
+	return "unhealthy read replicas, reads are routed to the primary: " + strings.Join(unhealthy, "; ")
+}
+
+// NonFatal reports that the database keeps serving reads and writes.
+func (e *ReplicaError) NonFatal() bool {
+	return true
+}
+
+// replicaChecker probes the read replicas periodically. Unhealthy replicas
+// are routed to the primary by WPGX.WQuerier until a probe succeeds again.
+type replicaChecker struct {
+	appName  string
+	interval time.Duration
+	maxLag   time.Duration
+
+	mu       sync.RWMutex
+	statuses map[wpgx.ReplicaName]*ReplicaStatus
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (c *replicaChecker) start(pool *wpgx.Pool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.probeAll(ctx, pool)
+			}
+		}
+	}()
+}
+
+func (c *replicaChecker) stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+func (c *replicaChecker) probeAll(ctx context.Context, pool *wpgx.Pool) {
+	primary := pool.RawPrimaryPool()
+	var wg sync.WaitGroup
+	for name, replica := range pool.ReplicaPools() {
+		// broken replicas share the primary pool, they are not routed anyway.
+		if replica == primary {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.probe(ctx, name, replica)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *replicaChecker) probe(ctx context.Context, name wpgx.ReplicaName, replica *pgxpool.Pool) {
+	ctx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+	status := &ReplicaStatus{Name: name, CheckedAt: time.Now()}
+	var lag float64
+	// probes go through the gate of async migrations.
+	err := replica.QueryRow(ctx, replicaLagSQL).Scan(&lag)
+	if err == nil {
+		status.Lag = time.Duration(lag * float64(time.Second))
+		if c.maxLag > 0 && status.Lag > c.maxLag {
+			err = fmt.Errorf("lag %s exceeds %s", status.Lag, c.maxLag)
+		}
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	status.Healthy = err == nil
+
+	c.mu.Lock()
+	prev, known := c.statuses[name]
+	c.statuses[name] = status
+	c.mu.Unlock()
+
+	if ctx.Err() == nil && (!known || prev.Healthy != status.Healthy) {
+		if status.Healthy {
+			log.Ctx(ctx).Info().Msgf("read replica %s is healthy, lag %s", name, status.Lag)
+		} else {
+			log.Ctx(ctx).Error().Err(err).Msgf("read replica %s is unhealthy, reads are routed to the primary", name)
+		}
+	}
+	healthy := 0.0
+	if status.Healthy {
+		healthy = 1
+	}
+	replicaHealthy.WithLabelValues(c.appName, string(name)).Set(healthy)
+	replicaLag.WithLabelValues(c.appName, string(name)).Set(status.Lag.Seconds())
+}
+
+// healthy reports whether name passed its last probe. Replicas not probed
+// yet are healthy.
+func (c *replicaChecker) healthy(name wpgx.ReplicaName) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	status, ok := c.statuses[name]
+	return !ok || status.Healthy
+}
+
+func (c *replicaChecker) list() []ReplicaStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	statuses := make([]ReplicaStatus, 0, len(c.statuses))
+	for _, status := range c.statuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// ReplicaStatuses returns the status of every read replica from its last
+// probe, nil without WithReplicaCheck. OK returns them in a ReplicaError
+// when one is unhealthy.
+func (w *WPGX) ReplicaStatuses() []ReplicaStatus {
+	if w.replicas == nil {
+		return nil
+	}
+	return w.replicas.list()
+}
@@ -0,0 +1,52 @@
+package wpgx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stumble/wpgx"
+)
+
+func TestWithReplicaCheck_OptIn(t *testing.T) {
+	w := NewWPGXWithOptions(WithConfig(&wpgx.Config{}))
+	if w.replicaCheckInterval != 0 {
+		t.Errorf("Expected replica checks to be off by default, got every %s", w.replicaCheckInterval)
+	}
+	if w.ReplicaStatuses() != nil {
+		t.Errorf("Expected no replica statuses without checks")
+	}
+
+	w = NewWPGXWithOptions(WithConfig(&wpgx.Config{}), WithReplicaCheck(0, time.Minute))
+	if w.replicaCheckInterval != defaultReplicaCheckInterval || w.replicaMaxLag != time.Minute {
+		t.Errorf("Expected checks every %s with a max lag of 1m, got %s and %s",
+			defaultReplicaCheckInterval, w.replicaCheckInterval, w.replicaMaxLag)
+	}
+}
+
+func TestReplicaChecker_Healthy(t *testing.T) {
+	c := &replicaChecker{statuses: map[wpgx.ReplicaName]*ReplicaStatus{
+		"down": {Name: "down", Error: "connection refused"},
+		"up":   {Name: "up", Healthy: true},
+	}}
+	if c.healthy("down") || !c.healthy("up") || !c.healthy("unknown") {
+		t.Errorf("Expected only down to be unhealthy")
+	}
+	if statuses := c.list(); len(statuses) != 2 || statuses[0].Name != "down" {
+		t.Errorf("Expected statuses sorted by name, got %+v", statuses)
+	}
+}
+
+func TestReplicaError(t *testing.T) {
+	err := &ReplicaError{Statuses: []ReplicaStatus{
+		{Name: "r1", Healthy: true},
+		{Name: "r2", Error: "lag 1m0s exceeds 10s"},
+		{Name: "r3", Error: "connection refused"},
+	}}
+	want := "unhealthy read replicas, reads are routed to the primary: r2: lag 1m0s exceeds 10s; r3: connection refused"
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+	if !err.NonFatal() {
+		t.Error("Expected unhealthy replicas not to be fatal")
+	}
+}
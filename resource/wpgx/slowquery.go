@@ -0,0 +1,143 @@
+package wpgx
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+	"github.com/stumble/wpgx"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// slowQuerier logs queries slower than threshold. Arguments are never
+// logged, only the query name and its SQL with collapsed whitespace.
+type slowQuerier struct {
+	q         wpgx.WQuerier
+	replica   string
+	threshold time.Duration
+}
+
+var _ wpgx.WQuerier = (*slowQuerier)(nil)
+
+func (s *slowQuerier) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (pgx.Rows, error) {
+	start := time.Now()
+	rows, err := s.q.WQuery(ctx, name, unprepared, args...)
+	if err != nil {
+		s.observe(ctx, name, unprepared, start, err)
+		return rows, err
+	}
+	return &slowRows{Rows: rows, done: func() {
+		s.observe(ctx, name, unprepared, start, rows.Err())
+	}}, nil
+}
+
+func (s *slowQuerier) WQueryRow(ctx context.Context, name string, unprepared string, args ...interface{}) pgx.Row {
+	return &slowRow{
+		row:   s.q.WQueryRow(ctx, name, unprepared, args...),
+		start: time.Now(),
+		done: func(start time.Time, err error) {
+			s.observe(ctx, name, unprepared, start, err)
+		},
+	}
+}
+
+func (s *slowQuerier) CountIntent(name string) {
+	s.q.CountIntent(name)
+}
+
+func (s *slowQuerier) observe(ctx context.Context, name string, sql string, start time.Time, err error) {
+	d := time.Since(start)
+	if d < s.threshold {
+		return
+	}
+	event := log.Ctx(ctx).Warn().
+		Str("query", name).
+		Str("sql", sanitizeSQL(sql)).
+		Str("replica", s.replica).
+		Dur("duration", d)
+	if err != nil {
+		event = event.Err(err)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		event = event.Str("trace_id", sc.TraceID().String())
+	}
+	event.Msg("slow query")
+}
+
+// slowRows measures WQuery until its rows are read or closed, so that the
+// time spent iterating them is included.
+type slowRows struct {
+	pgx.Rows
+	done     func()
+	observed bool
+}
+
+func (r *slowRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.observe()
+	return false
+}
+
+func (r *slowRows) Close() {
+	r.Rows.Close()
+	r.observe()
+}
+
+func (r *slowRows) observe() {
+	if !r.observed {
+		r.observed = true
+		r.done()
+	}
+}
+
+// slowRow measures WQueryRow until Scan, when the query is actually run.
+type slowRow struct {
+	row   pgx.Row
+	start time.Time
+	done  func(start time.Time, err error)
+}
+
+func (r *slowRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	r.done(r.start, err)
+	return err
+}
+
+// slowConn is a slowQuerier over a wpgx.WGConn.
+type slowConn struct {
+	slowQuerier
+	c wpgx.WGConn
+}
+
+var _ wpgx.WGConn = (*slowConn)(nil)
+
+func (s *slowConn) WExec(ctx context.Context, name string, unprepared string, args ...interface{}) (pgconn.CommandTag, error) {
+	start := time.Now()
+	tag, err := s.c.WExec(ctx, name, unprepared, args...)
+	s.observe(ctx, name, unprepared, start, err)
+	return tag, err
+}
+
+func (s *slowConn) PostExec(f wpgx.PostExecFunc) error {
+	return s.c.PostExec(f)
+}
+
+func (s *slowConn) WCopyFrom(
+	ctx context.Context, name string, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource,
+) (int64, error) {
+	start := time.Now()
+	n, err := s.c.WCopyFrom(ctx, name, tableName, columnNames, rowSrc)
+	s.observe(ctx, name, "COPY "+tableName.Sanitize(), start, err)
+	return n, err
+}
+
+// sanitizeSQL collapses whitespace so that multi-line queries fit on one
+// log line. Queries are parameterized, values are not part of the SQL.
+func sanitizeSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
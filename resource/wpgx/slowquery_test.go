@@ -0,0 +1,100 @@
+package wpgx
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+)
+
+type sleepQuerier struct {
+	delay time.Duration
+	rows  int
+}
+
+func (q *sleepQuerier) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (pgx.Rows, error) {
+	time.Sleep(q.delay)
+	return &sleepRows{delay: q.delay, left: q.rows}, nil
+}
+
+func (q *sleepQuerier) WQueryRow(ctx context.Context, name string, unprepared string, args ...interface{}) pgx.Row {
+	return &sleepRows{delay: q.delay}
+}
+
+func (q *sleepQuerier) CountIntent(name string) {}
+
+// sleepRows takes delay to return each row.
+type sleepRows struct {
+	pgx.Rows
+	delay time.Duration
+	left  int
+}
+
+func (r *sleepRows) Next() bool {
+	if r.left == 0 {
+		return false
+	}
+	time.Sleep(r.delay)
+	r.left--
+	return true
+}
+
+func (r *sleepRows) Scan(dest ...any) error {
+	time.Sleep(r.delay)
+	return nil
+}
+
+func (r *sleepRows) Close() {}
+
+func (r *sleepRows) Err() error {
+	return nil
+}
+
+func TestSlowQuerier_LogsQueriesOverThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+
+	fast := &slowQuerier{q: &sleepQuerier{}, replica: "primary", threshold: time.Second}
+	rows, _ := fast.WQuery(ctx, "GetUser", "SELECT 1")
+	rows.Close()
+	if buf.Len() != 0 {
+		t.Fatalf("Expected no log for a fast query, got %s", buf.String())
+	}
+
+	slow := &slowQuerier{q: &sleepQuerier{delay: 10 * time.Millisecond}, replica: "primary", threshold: time.Millisecond}
+	_ = slow.WQueryRow(ctx, "ListUsers", "SELECT *\n\t\tFROM users\n\t\tWHERE email = $1", "alice@example.com").Scan()
+	out := buf.String()
+	if !strings.Contains(out, `"query":"ListUsers"`) || !strings.Contains(out, `"sql":"SELECT * FROM users WHERE email = $1"`) {
+		t.Errorf("Expected slow query log with sanitized SQL, got %s", out)
+	}
+	if strings.Contains(out, "alice@example.com") {
+		t.Errorf("Expected arguments not to be logged, got %s", out)
+	}
+}
+
+func TestSlowQuerier_MeasuresRowsIteration(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+
+	slow := &slowQuerier{q: &sleepQuerier{delay: 20 * time.Millisecond, rows: 3}, replica: "replica1", threshold: 50 * time.Millisecond}
+	rows, err := slow.WQuery(ctx, "ListUsers", "SELECT * FROM users")
+	if err != nil {
+		t.Fatalf("WQuery failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Expected nothing logged before the rows are read, got %s", buf.String())
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if n := strings.Count(buf.String(), "slow query"); n != 1 {
+		t.Errorf("Expected the query logged once after its rows are read, got %d logs: %s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), `"replica":"replica1"`) {
+		t.Errorf("Expected the replica to be logged, got %s", buf.String())
+	}
+}
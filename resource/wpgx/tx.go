@@ -0,0 +1,174 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+	"github.com/stumble/wpgx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	name = "github.com/ggsrc/gglib/resource/wpgx"
+
+	defaultTxMaxAttempts = 3
+	defaultTxBackoff     = 50 * time.Millisecond
+	maxTxBackoff         = time.Second
+
+	pgCodeSerializationFailure = "40001"
+	pgCodeDeadlockDetected     = "40P01"
+)
+
+var tracer = otel.Tracer(name)
+
+// TxFunc is the body of a transaction. It may run several times when the
+// transaction is retried, so it must not have side effects outside of tx.
+type TxFunc func(ctx context.Context, tx *wpgx.WTx) error
+
+// TxOptions configures WithTx. The zero value runs a read-write transaction
+// with the default isolation level of the database.
+type TxOptions struct {
+	IsoLevel   pgx.TxIsoLevel
+	ReadOnly   bool
+	Deferrable bool
+	// MaxAttempts bounds the attempts on serialization failures and
+	// deadlocks, 0 means 3. Set it to 1 to disable retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled on each retry up
+	// to 1s, 0 means 50ms.
+	Backoff time.Duration
+}
+
+func (o *TxOptions) pgxOptions() pgx.TxOptions {
+	opts := pgx.TxOptions{IsoLevel: o.IsoLevel}
+	if o.ReadOnly {
+		opts.AccessMode = pgx.ReadOnly
+	}
+	if o.Deferrable {
+		opts.DeferrableMode = pgx.Deferrable
+	}
+	return opts
+}
+
+// txKey is the ctx key of the transaction running on w, so that WithTx on
+// another database does not join it.
+type txKey struct {
+	w *WPGX
+}
+
+// txState is the transaction running in a ctx passed to a TxFunc.
+type txState struct {
+	tx    *wpgx.WTx
+	depth int
+}
+
+// WithTx runs fn in a transaction on the primary pool and commits it if fn
+// returns nil. It is retried with exponential backoff on serialization
+// failures (40001) and deadlocks (40P01).
+//
+// WithTx called with a ctx from a transaction running on w runs fn in a
+// savepoint of that transaction instead, opts are ignored and it is never retried:
+// the error aborts the outer transaction, which retries as a whole.
+func (w *WPGX) WithTx(ctx context.Context, opts *TxOptions, fn TxFunc) error {
+	if state, ok := ctx.Value(txKey{w: w}).(*txState); ok {
+		return w.withSavepoint(ctx, state, fn)
+	}
+	if w.pool == nil {
+		return errors.New("wpgx not initialized")
+	}
+	if err := w.waitGate(ctx); err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultTxMaxAttempts
+	}
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = defaultTxBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := w.runTx(ctx, opts, attempt, fn)
+		if err == nil || attempt >= maxAttempts || !IsRetryableTxError(err) {
+			return err
+		}
+		// nolint: gosec
+		delay := backoff/2 + rand.N(backoff/2+1)
+		log.Ctx(ctx).Warn().Err(err).Msgf("transaction attempt %d failed, retrying in %s", attempt, delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, maxTxBackoff)
+	}
+}
+
+func (w *WPGX) runTx(ctx context.Context, opts *TxOptions, attempt int, fn TxFunc) (err error) {
+	ctx, span := tracer.Start(ctx, "wpgx.tx", trace.WithAttributes(
+		attribute.Int("db.tx.attempt", attempt),
+		attribute.String("db.tx.isolation", string(opts.IsoLevel)),
+		attribute.Bool("db.tx.read_only", opts.ReadOnly),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	_, err = w.pool.Transact(ctx, opts.pgxOptions(), func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+		return nil, fn(context.WithValue(ctx, txKey{w: w}, &txState{tx: tx}), tx)
+	})
+	return err
+}
+
+func (w *WPGX) withSavepoint(ctx context.Context, state *txState, fn TxFunc) (err error) {
+	savepoint := fmt.Sprintf("wpgx_sp_%d", state.depth+1)
+	ctx, span := tracer.Start(ctx, "wpgx.savepoint", trace.WithAttributes(
+		attribute.String("db.tx.savepoint", savepoint),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	tx := state.tx
+	if _, err = tx.WExec(ctx, "savepoint", "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+	if err = fn(context.WithValue(ctx, txKey{w: w}, &txState{tx: tx, depth: state.depth + 1}), tx); err != nil {
+		_, rollbackErr := tx.WExec(context.WithoutCancel(ctx), "rollback_to_savepoint", "ROLLBACK TO SAVEPOINT "+savepoint)
+		if rollbackErr != nil {
+			err = fmt.Errorf("rollback to savepoint error: %w, original error: %w", rollbackErr, err)
+		}
+		return err
+	}
+	_, err = tx.WExec(ctx, "release_savepoint", "RELEASE SAVEPOINT "+savepoint)
+	return err
+}
+
+// IsRetryableTxError reports whether err is a serialization failure or a
+// deadlock, after which the whole transaction can be retried.
+func IsRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgCodeSerializationFailure || pgErr.Code == pgCodeDeadlockDetected
+}
@@ -0,0 +1,28 @@
+package wpgx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: pgCodeSerializationFailure}, true},
+		{&pgconn.PgError{Code: pgCodeDeadlockDetected}, true},
+		{fmt.Errorf("commit: %w", &pgconn.PgError{Code: pgCodeSerializationFailure}), true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{errors.New("connection reset"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryableTxError(tt.err); got != tt.want {
+			t.Errorf("IsRetryableTxError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package wpgx
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -19,6 +21,9 @@ type TypeLoaderParam struct {
 
 var defaultLoader *Loader
 
+// InitTypeLoader initializes the package-wide loader used by LoadTypes.
+//
+// Deprecated: use WithTypeLoader, which preloads types in WPGX.Init.
 func InitTypeLoader(p *TypeLoaderParam) {
 	if p == nil {
 		cfg := TypeLoaderParam{}
@@ -29,6 +34,9 @@ func InitTypeLoader(p *TypeLoaderParam) {
 	defaultLoader = NewLoader(p)
 }
 
+// LoadTypes registers the types of the package-wide loader on conn.
+//
+// Deprecated: use WithTypeLoader, which preloads types in WPGX.Init.
 func LoadTypes(ctx context.Context, conn *pgx.Conn) bool {
 	if defaultLoader == nil {
 		// no type loader, skip
@@ -49,7 +57,6 @@ func Reset() {
 
 func NewLoader(p *TypeLoaderParam) *Loader {
 	l := Loader{
-		ptMap:   map[string]*pgtype.Type{},
 		timeout: defaultTimeout,
 	}
 	if p.Timeout > 0 {
@@ -66,40 +73,96 @@ func NewLoader(p *TypeLoaderParam) *Loader {
 	return &l
 }
 
+// Loader loads the configured types from the database once and registers
+// them on every connection. It is safe for concurrent use.
 type Loader struct {
-	ptMap   map[string]*pgtype.Type
+	mu      sync.RWMutex
+	loaded  bool
+	types   []*pgtype.Type // in dependency order
 	timeout time.Duration
 	ts      []string
 }
 
-func (l *Loader) LoadTypes(ctx context.Context, conn *pgx.Conn) bool {
+// Preload loads the configured types, along with the array, composite,
+// domain and range types they depend on, and registers them on conn. It
+// fails if a configured type does not exist.
+func (l *Loader) Preload(ctx context.Context, conn *pgx.Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.preload(ctx, conn)
+}
+
+func (l *Loader) preload(ctx context.Context, conn *pgx.Conn) error {
+	if len(l.ts) == 0 {
+		l.loaded = true
+		return nil
+	}
 	ctx, cancel := context.WithTimeout(ctx, l.timeout)
 	defer cancel()
+	types, err := conn.LoadTypes(ctx, l.ts)
+	if err != nil {
+		return fmt.Errorf("failed to load types %v: %w", l.ts, err)
+	}
 	for _, t := range l.ts {
-		// reuse type if already registered in conn
-		if _, ok := conn.TypeMap().TypeForName(t); ok {
-			continue
+		if _, ok := conn.TypeMap().TypeForName(t); !ok {
+			return fmt.Errorf("type %s not found", t)
 		}
-		if pt, ok := l.ptMap[t]; ok {
-			// reuse type if already loaded in ptMap
-			conn.TypeMap().RegisterType(pt)
-		} else {
-			var err error
-			// load type from database
-			if pt, err = conn.LoadType(ctx, t); err != nil {
-				log.Err(err).Msgf("failed to load type %s", t)
-				return true
+	}
+	for _, t := range types {
+		log.Info().Msgf("loaded type %s", t.Name)
+	}
+	l.types = types
+	l.loaded = true
+	return nil
+}
+
+// LoadTypes registers the loaded types on conn and always returns true, so
+// that it can be used as BeforeAcquire. Types are loaded on first use if
+// Preload was not called, errors are then only logged.
+func (l *Loader) LoadTypes(ctx context.Context, conn *pgx.Conn) bool {
+	l.mu.RLock()
+	loaded, types := l.loaded, l.types
+	l.mu.RUnlock()
+	if !loaded {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !l.loaded {
+			if err := l.preload(ctx, conn); err != nil {
+				log.Err(err).Msg("failed to load types")
 			}
-			log.Info().Msgf("loaded type %s", t)
-			conn.TypeMap().RegisterType(pt)
-			l.ptMap[t] = pt
+			return true
+		}
+		types = l.types
+	}
+	m := conn.TypeMap()
+	for _, t := range types {
+		// reuse type if already registered in conn. Types are looked up by
+		// name: the schema qualified and the unqualified name of a type
+		// share its OID, and both must be registered.
+		if _, ok := m.TypeForName(t.Name); !ok {
+			m.RegisterType(t)
 		}
 	}
 	return true
 }
 
+// Types returns the names of the registered types, including dependencies
+// and schema qualified names, in dependency order.
+func (l *Loader) Types() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make([]string, len(l.types))
+	for i, t := range l.types {
+		names[i] = t.Name
+	}
+	return names
+}
+
 func (l *Loader) Reset() {
-	l.ptMap = map[string]*pgtype.Type{}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loaded = false
+	l.types = nil
 	l.ts = []string{}
 	l.timeout = defaultTimeout
 }
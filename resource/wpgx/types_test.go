@@ -0,0 +1,97 @@
+package wpgx_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ggsrc/gglib/resource/wpgx"
+	"github.com/ggsrc/gglib/resource/wpgx/wpgxtest"
+)
+
+var typesMigrations = fstest.MapFS{
+	"1_create_people.up.sql": {Data: []byte(`CREATE TYPE mood AS ENUM ('happy', 'sad');
+CREATE TYPE address AS (street text, city text);
+CREATE TABLE people (id bigint PRIMARY KEY, addr address NOT NULL, moods mood[] NOT NULL);`)},
+}
+
+type address struct {
+	Street string
+	City   string
+}
+
+func TestWithTypeLoader(t *testing.T) {
+	db := wpgxtest.New(t,
+		wpgxtest.WithMigrations(typesMigrations),
+		wpgxtest.WithWPGXOptions(wpgx.WithTypeLoader(&wpgx.TypeLoaderParam{Types: []string{"address", "mood", "_mood"}})),
+	)
+	ctx := context.Background()
+
+	types := db.TypeLoader().Types()
+	for _, name := range []string{"address", "mood", "_mood"} {
+		if !slices.Contains(types, name) {
+			t.Errorf("Expected %s to be preloaded, got %v", name, types)
+		}
+	}
+
+	if _, err := db.WConn().WExec(ctx, "insert_person",
+		"INSERT INTO people (id, addr, moods) VALUES (1, $1, $2)",
+		address{Street: "1 Main St", City: "Springfield"}, []string{"happy", "sad"}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	var (
+		addr  address
+		moods []string
+	)
+	if err := db.WConn().WQueryRow(ctx, "get_person", "SELECT addr, moods FROM people WHERE id = 1").Scan(&addr, &moods); err != nil {
+		t.Fatalf("failed to scan registered types: %v", err)
+	}
+	if addr.City != "Springfield" || !slices.Equal(moods, []string{"happy", "sad"}) {
+		t.Errorf("Unexpected person %+v %v", addr, moods)
+	}
+}
+
+func TestWithTypeLoader_RegistersOnEveryConnection(t *testing.T) {
+	db := wpgxtest.New(t,
+		wpgxtest.WithMigrations(typesMigrations),
+		wpgxtest.WithWPGXOptions(wpgx.WithTypeLoader(&wpgx.TypeLoaderParam{Types: []string{"address", "mood", "_mood"}})),
+	)
+	ctx := context.Background()
+
+	// holding the first connection makes the pool open a second one,
+	// which did not preload the types.
+	pool := db.GetPool().RawPrimaryPool()
+	var conns []*pgxpool.Conn
+	for range 2 {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatalf("failed to acquire connection: %v", err)
+		}
+		defer conn.Release()
+		conns = append(conns, conn)
+	}
+	if conns[0].Conn().PgConn().PID() == conns[1].Conn().PgConn().PID() {
+		t.Fatalf("Expected two distinct connections")
+	}
+	for i, conn := range conns {
+		for _, name := range []string{"address", "mood", "_mood", "public.address", "public.mood"} {
+			if _, ok := conn.Conn().TypeMap().TypeForName(name); !ok {
+				t.Errorf("Expected %s to be registered on connection %d", name, i)
+			}
+		}
+	}
+}
+
+func TestLoader_PreloadFailsOnMissingType(t *testing.T) {
+	db := wpgxtest.New(t)
+	loader := wpgx.NewLoader(&wpgx.TypeLoaderParam{Types: []string{"missing_type"}})
+	err := db.GetPool().RawPrimaryPool().AcquireFunc(context.Background(), func(conn *pgxpool.Conn) error {
+		return loader.Preload(context.Background(), conn.Conn())
+	})
+	if err == nil {
+		t.Errorf("Expected a missing type to fail Preload")
+	}
+}
@@ -0,0 +1,137 @@
+package wpgx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	stumblewpgx "github.com/stumble/wpgx"
+
+	"github.com/ggsrc/gglib/resource/wpgx"
+	"github.com/ggsrc/gglib/resource/wpgx/wpgxtest"
+)
+
+var itemsMigrations = fstest.MapFS{
+	"1_create_items.up.sql": {Data: []byte("CREATE TABLE items (id bigint PRIMARY KEY);")},
+}
+
+func insertItem(ctx context.Context, tx *stumblewpgx.WTx, id int64) error {
+	_, err := tx.WExec(ctx, "insert_item", "INSERT INTO items (id) VALUES ($1)", id)
+	return err
+}
+
+func itemIDs(t *testing.T, db *wpgxtest.DB) []int64 {
+	t.Helper()
+	rows, err := db.WConn().WQuery(context.Background(), "list_items", "SELECT id FROM items ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	return ids
+}
+
+func TestWithTx_NestedSavepoints(t *testing.T) {
+	db := wpgxtest.New(t, wpgxtest.WithMigrations(itemsMigrations))
+	ctx := context.Background()
+	errRollback := errors.New("rollback")
+
+	err := db.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+		if err := insertItem(ctx, tx, 1); err != nil {
+			return err
+		}
+		// rolled back to its savepoint, with the savepoint nested in it.
+		err := db.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+			if err := insertItem(ctx, tx, 2); err != nil {
+				return err
+			}
+			if err := db.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+				return insertItem(ctx, tx, 3)
+			}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Errorf("Expected the savepoint error, got %v", err)
+		}
+		return db.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+			return db.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+				return insertItem(ctx, tx, 4)
+			})
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+	if ids := itemIDs(t, db); len(ids) != 2 || ids[0] != 1 || ids[1] != 4 {
+		t.Errorf("Expected items [1 4], got %v", ids)
+	}
+}
+
+func TestWithTx_Retries(t *testing.T) {
+	db := wpgxtest.New(t, wpgxtest.WithMigrations(itemsMigrations))
+	ctx := context.Background()
+	serializationFailure := &pgconn.PgError{Code: "40001"}
+
+	attempts := 0
+	err := db.WithTx(ctx, &wpgx.TxOptions{IsoLevel: pgx.Serializable}, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+		attempts++
+		if err := insertItem(ctx, tx, 1); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return serializationFailure
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("Expected success after 3 attempts, got %v after %d", err, attempts)
+	}
+	if ids := itemIDs(t, db); len(ids) != 1 {
+		t.Errorf("Expected the failed attempts to be rolled back, got items %v", ids)
+	}
+
+	attempts = 0
+	err = db.WithTx(ctx, &wpgx.TxOptions{MaxAttempts: 1}, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+		attempts++
+		return serializationFailure
+	})
+	if !wpgx.IsRetryableTxError(err) || attempts != 1 {
+		t.Errorf("Expected one failed attempt, got %v after %d", err, attempts)
+	}
+}
+
+func TestWithTx_OtherDatabaseIsNotJoined(t *testing.T) {
+	a := wpgxtest.New(t, wpgxtest.WithMigrations(itemsMigrations))
+	b := wpgxtest.New(t, wpgxtest.WithMigrations(itemsMigrations))
+	ctx := context.Background()
+	errRollback := errors.New("rollback")
+
+	err := a.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+		if err := insertItem(ctx, tx, 1); err != nil {
+			return err
+		}
+		// its own transaction on b, committed even though a rolls back.
+		if err := b.WithTx(ctx, nil, func(ctx context.Context, tx *stumblewpgx.WTx) error {
+			return insertItem(ctx, tx, 2)
+		}); err != nil {
+			t.Errorf("WithTx on b failed: %v", err)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Errorf("Expected the rollback error, got %v", err)
+	}
+	if ids := itemIDs(t, a); len(ids) != 0 {
+		t.Errorf("Expected no items in a, got %v", ids)
+	}
+	if ids := itemIDs(t, b); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("Expected items [2] in b, got %v", ids)
+	}
+}
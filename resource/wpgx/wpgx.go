@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
 	"github.com/stumble/wpgx"
 )
 
@@ -14,7 +18,15 @@ type WPGX struct {
 	pool          *wpgx.Pool
 	once          sync.Once
 	beforeAcquire func(context.Context, *pgx.Conn) bool
+	loader        *Loader
 	config        *wpgx.Config
+	collector     *poolCollector
+	replicas      *replicaChecker
+	gate          atomic.Pointer[gate]
+
+	slowQueryThreshold   time.Duration
+	replicaCheckInterval time.Duration
+	replicaMaxLag        time.Duration
 }
 
 func NewWPGXWithDefaultEnvPrefix() *WPGX {
@@ -41,6 +53,22 @@ func (w *WPGX) Init(ctx context.Context) error {
 	var err error
 	w.once.Do(func() {
 		w.pool, err = w.newWPGXPool(ctx)
+		if err != nil {
+			return
+		}
+		w.collector = newPoolCollector(w.config.AppName, w.pool)
+		if regErr := prometheus.Register(w.collector); regErr != nil {
+			log.Ctx(ctx).Err(regErr).Msg("failed to register wpgx pool collector")
+		}
+		if w.replicaCheckInterval > 0 {
+			w.replicas = &replicaChecker{
+				appName:  w.config.AppName,
+				interval: w.replicaCheckInterval,
+				maxLag:   w.replicaMaxLag,
+				statuses: map[wpgx.ReplicaName]*ReplicaStatus{},
+			}
+			w.replicas.probeAll(ctx, w.pool)
+		}
 	})
 	w.initialized = true
 	return err
@@ -50,23 +78,89 @@ func (w *WPGX) Start(ctx context.Context) error {
 	if !w.initialized {
 		return errors.New("wpgx not initialized")
 	}
+	if w.replicas != nil && w.replicas.cancel == nil {
+		w.replicas.start(w.pool)
+	}
 	return nil
 }
 
 func (w *WPGX) Stop(ctx context.Context) error {
+	if w.replicas != nil {
+		w.replicas.stop()
+	}
+	if w.collector != nil {
+		prometheus.Unregister(w.collector)
+	}
 	if w.pool != nil {
 		w.pool.Close()
 	}
 	return nil
 }
 
+// OK pings the primary and the read replicas. With WithReplicaCheck,
+// replicas are probed in the background instead: unhealthy replicas are
+// routed around and reported by a non-fatal *ReplicaError listing the
+// status of every replica.
 func (w *WPGX) OK(ctx context.Context) error {
-	if w.pool != nil {
+	if w.pool == nil {
+		return nil
+	}
+	if w.replicas == nil {
 		return w.pool.Ping(ctx)
 	}
+	if err := w.pool.PingPrimary(ctx); err != nil {
+		return err
+	}
+	statuses := w.replicas.list()
+	for _, status := range statuses {
+		if !status.Healthy {
+			return &ReplicaError{Statuses: statuses}
+		}
+	}
 	return nil
 }
 
+// GetPool returns the underlying pool. Its WQuerier is not routed around
+// unhealthy replicas, use WPGX.WQuerier for reads.
 func (w *WPGX) GetPool() *wpgx.Pool {
 	return w.pool
 }
+
+// WConn returns a connection to the primary, which logs slow queries if
+// WithSlowQueryThreshold is set and waits for the gate set by Gate.
+func (w *WPGX) WConn() wpgx.WGConn {
+	var conn wpgx.WGConn = w.pool.WConn()
+	if w.slowQueryThreshold > 0 {
+		conn = &slowConn{
+			slowQuerier: slowQuerier{q: conn, replica: wpgx.ReservedReplicaNamePrimary, threshold: w.slowQueryThreshold},
+			c:           conn,
+		}
+	}
+	return &gatedConn{gatedQuerier: gatedQuerier{q: conn, w: w}, c: conn}
+}
+
+// WQuerier returns a querier on the replica name, or on the primary if name
+// is nil or the replica is unhealthy, which logs slow queries if
+// WithSlowQueryThreshold is set and waits for the gate set by Gate.
+func (w *WPGX) WQuerier(name *wpgx.ReplicaName) (wpgx.WQuerier, error) {
+	if name != nil && w.replicas != nil && !w.replicas.healthy(*name) {
+		name = nil
+	}
+	q, err := w.pool.WQuerier(name)
+	if err != nil {
+		return nil, err
+	}
+	if w.slowQueryThreshold > 0 {
+		replica := wpgx.ReservedReplicaNamePrimary
+		if name != nil {
+			replica = string(*name)
+		}
+		q = &slowQuerier{q: q, replica: replica, threshold: w.slowQueryThreshold}
+	}
+	return &gatedQuerier{q: q, w: w}, nil
+}
+
+// TypeLoader returns the loader set by WithTypeLoader, nil if none.
+func (w *WPGX) TypeLoader() *Loader {
+	return w.loader
+}
@@ -0,0 +1,98 @@
+package wpgxtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// the upstream wpgx testsuite already defines -update.
+var updateGolden = flag.Bool("update-golden", false, "update wpgxtest .golden files")
+
+// goldenPath returns testdata/{TestName}.{name}.golden, subtests included
+// in the file name.
+func goldenPath(tb testing.TB, name string) string {
+	return filepath.Join("testdata", fmt.Sprintf("%s.%s.golden", strings.ReplaceAll(tb.Name(), "/", "_"), name))
+}
+
+// GoldenTable compares the rows of table, as a JSON array ordered by
+// orderBy columns, with testdata/{TestName}.{table}.golden. Run the tests
+// with -update-golden to write the files.
+func (db *DB) GoldenTable(table string, orderBy ...string) {
+	db.tb.Helper()
+	query := fmt.Sprintf("SELECT COALESCE(jsonb_agg(to_jsonb(t)), '[]') FROM (SELECT * FROM %s", pgx.Identifier{table}.Sanitize())
+	if len(orderBy) > 0 {
+		cols := make([]string, len(orderBy))
+		for i, c := range orderBy {
+			cols[i] = pgx.Identifier{c}.Sanitize()
+		}
+		query += " ORDER BY " + strings.Join(cols, ", ")
+	}
+	query += ") t"
+	var rows json.RawMessage
+	if err := db.WConn().WQueryRow(context.Background(), "wpgxtest_dump", query).Scan(&rows); err != nil {
+		db.tb.Fatalf("failed to dump %s: %v", table, err)
+	}
+	golden(db.tb, table, rows)
+}
+
+// GoldenJSON compares v marshalled as JSON with
+// testdata/{TestName}.{name}.golden.
+func GoldenJSON(tb testing.TB, name string, v any) {
+	tb.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		tb.Fatalf("failed to marshal %s: %v", name, err)
+	}
+	golden(tb, name, b)
+}
+
+func golden(tb testing.TB, name string, data []byte) {
+	tb.Helper()
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		tb.Fatalf("failed to indent %s: %v", name, err)
+	}
+	buf.WriteByte('\n')
+	path := goldenPath(tb, name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			tb.Fatalf("failed to create testdata: %v", err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+			tb.Fatalf("failed to update %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path) // nolint: gosec
+	if err != nil {
+		tb.Fatalf("failed to read %s, run with -update-golden to create it: %v", path, err)
+	}
+	if !bytes.Equal(want, buf.Bytes()) {
+		tb.Errorf("%s differs from %s:\ngot:\n%s\nwant:\n%s", name, path, buf.Bytes(), want)
+	}
+}
+
+// LoadJSON inserts the rows of the JSON array in testdata/file into table,
+// keys matching columns.
+func (db *DB) LoadJSON(table, file string) {
+	db.tb.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", file)) // nolint: gosec
+	if err != nil {
+		db.tb.Fatalf("failed to read %s: %v", file, err)
+	}
+	t := pgx.Identifier{table}.Sanitize()
+	_, err = db.WConn().WExec(context.Background(), "wpgxtest_load",
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM jsonb_populate_recordset(NULL::%s, $1)", t, t), string(data))
+	if err != nil {
+		db.tb.Fatalf("failed to load %s into %s: %v", file, table, err)
+	}
+}
@@ -0,0 +1,116 @@
+package wpgxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const serverStartTimeout = 30 * time.Second
+
+var (
+	serverCmd *exec.Cmd
+	serverDir string
+)
+
+// Main runs the tests and stops the server started for them, if any. Call
+// it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		wpgxtest.Main(m)
+//	}
+func Main(m *testing.M) {
+	code := m.Run()
+	stopServer()
+	os.Exit(code)
+}
+
+// startServer initializes a cluster in a temporary directory and starts
+// postgres on a free local port, without durability.
+func startServer(conf *ServerConfig) error {
+	dir, err := os.MkdirTemp("", "wpgxtest")
+	if err != nil {
+		return err
+	}
+	serverDir = dir
+	dataDir := filepath.Join(dir, "data")
+	pwFile := filepath.Join(dir, "pwfile")
+	if err = os.WriteFile(pwFile, []byte(conf.Password), 0o600); err != nil {
+		return err
+	}
+	// nolint: gosec
+	initdb := exec.Command(filepath.Join(conf.PostgresBin, "initdb"),
+		"-D", dataDir, "-U", conf.Username, "--pwfile", pwFile, "-A", "scram-sha-256", "--no-sync")
+	if out, err := initdb.CombinedOutput(); err != nil {
+		return fmt.Errorf("initdb failed: %w: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+	// nolint: gosec
+	serverCmd = exec.Command(filepath.Join(conf.PostgresBin, "postgres"),
+		"-D", dataDir,
+		"-p", strconv.Itoa(port),
+		"-k", dir,
+		"-c", "listen_addresses=127.0.0.1",
+		"-c", "fsync=off",
+		"-c", "synchronous_commit=off",
+		"-c", "full_page_writes=off",
+		"-c", "max_connections=200")
+	serverCmd.Stdout = os.Stderr
+	serverCmd.Stderr = os.Stderr
+	if err = serverCmd.Start(); err != nil {
+		return err
+	}
+	conf.Host = "127.0.0.1"
+	conf.Port = port
+	return waitServer(conf)
+}
+
+func waitServer(conf *ServerConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), serverStartTimeout)
+	defer cancel()
+	for {
+		conn, err := conf.connect(ctx, "postgres")
+		if err == nil {
+			return conn.Close(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			stopServer()
+			return errors.Join(errors.New("postgres did not start in time"), err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func stopServer() {
+	if serverCmd != nil && serverCmd.Process != nil {
+		// SIGINT is the fast shutdown of postgres.
+		_ = serverCmd.Process.Signal(os.Interrupt)
+		_ = serverCmd.Wait()
+		serverCmd = nil
+	}
+	if serverDir != "" {
+		_ = os.RemoveAll(serverDir)
+		serverDir = ""
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
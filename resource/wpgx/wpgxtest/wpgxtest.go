@@ -0,0 +1,345 @@
+// Package wpgxtest creates an isolated Postgres database per test and
+// returns an initialized wpgx.WPGX connected to it.
+//
+// Databases are created on the server configured by the WPGXTEST_* env
+// variables, a local server by default. If WPGXTEST_POSTGRESBIN is set to
+// the directory of the initdb and postgres binaries, a throwaway server is
+// started instead; call Main from TestMain to stop it when tests end. If no
+// WPGXTEST_* variable is set and no local server answers, tests calling New
+// are skipped.
+//
+// Migrations are applied once to a template database, which every test
+// database is cloned from.
+package wpgxtest
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kelseyhightower/envconfig"
+	stumblewpgx "github.com/stumble/wpgx"
+
+	"github.com/ggsrc/gglib/resource/wpgx"
+	"github.com/ggsrc/gglib/resource/wpgx/migrate"
+)
+
+const (
+	appName = "wpgxtest"
+	// maxDBNameLen is the Postgres identifier length limit.
+	maxDBNameLen = 63
+	// probeTimeout is how long the default server is waited for when no
+	// WPGXTEST_* variable is set.
+	probeTimeout = 2 * time.Second
+)
+
+var errNoServer = errors.New("no postgres server configured or reachable, set WPGXTEST_*")
+
+type ServerConfig struct {
+	Host     string `default:"localhost"`
+	Port     int    `default:"5432"`
+	Username string `default:"postgres"`
+	Password string `default:"my-secret"`
+	// PostgresBin is the directory of initdb and postgres to start a
+	// throwaway server with, instead of using Host and Port.
+	PostgresBin string `default:""`
+}
+
+// DB is an isolated database with an initialized WPGX, dropped when the
+// test finishes.
+type DB struct {
+	*wpgx.WPGX
+	Name string
+	// Config is the config of the WPGX, to connect to the database
+	// otherwise, e.g. with a notify.Listener.
+	Config *stumblewpgx.Config
+	tb     testing.TB
+}
+
+type options struct {
+	migrations fs.FS
+	wpgxOpts   []wpgx.Options
+	replicas   []stumblewpgx.ReadReplicaConfig
+}
+
+type Option func(*options)
+
+// WithMigrations applies the migrations of fsys, see package migrate.
+func WithMigrations(fsys fs.FS) Option {
+	return func(o *options) {
+		o.migrations = fsys
+	}
+}
+
+// WithReadReplicas adds read replicas to the config of the WPGX. Their
+// connection settings default to those of the test database, so a replica
+// with only a Name reads from the primary, and one with another DBName can
+// stand for an unreachable replica.
+func WithReadReplicas(replicas ...stumblewpgx.ReadReplicaConfig) Option {
+	return func(o *options) {
+		o.replicas = append(o.replicas, replicas...)
+	}
+}
+
+// WithWPGXOptions are applied to the WPGX of the test database, after its
+// config.
+func WithWPGXOptions(opts ...wpgx.Options) Option {
+	return func(o *options) {
+		o.wpgxOpts = append(o.wpgxOpts, opts...)
+	}
+}
+
+var (
+	serverOnce sync.Once
+	server     *ServerConfig
+	serverErr  error
+
+	templatesMu sync.Mutex
+	templates   = map[string]string{}
+)
+
+// New creates a database for tb, cloned from the template of the
+// migrations if any, and returns it with an initialized WPGX.
+func New(tb testing.TB, opts ...Option) *DB {
+	tb.Helper()
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	ctx := context.Background()
+	srv, err := getServer()
+	if errors.Is(err, errNoServer) {
+		tb.Skipf("skipping: %v", err)
+	}
+	if err != nil {
+		tb.Fatalf("failed to get postgres server: %v", err)
+	}
+
+	template := "template0"
+	if o.migrations != nil {
+		if template, err = getTemplate(ctx, srv, o.migrations); err != nil {
+			tb.Fatalf("failed to prepare template database: %v", err)
+		}
+	}
+	name := dbName(tb.Name())
+	err = adminExec(ctx, srv, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+		pgx.Identifier{name}.Sanitize(), pgx.Identifier{template}.Sanitize()))
+	if err != nil {
+		tb.Fatalf("failed to create database %s: %v", name, err)
+	}
+
+	config := srv.wpgxConfig(name)
+	for _, r := range o.replicas {
+		config.ReadReplicas = append(config.ReadReplicas, srv.replicaConfig(name, r))
+	}
+	w := wpgx.NewWPGXWithOptions(append([]wpgx.Options{wpgx.WithConfig(config)}, o.wpgxOpts...)...)
+	db := &DB{WPGX: w, Name: name, Config: config, tb: tb}
+	tb.Cleanup(func() {
+		if err := w.Stop(ctx); err != nil {
+			tb.Errorf("failed to stop wpgx: %v", err)
+		}
+		if err := adminExec(ctx, srv, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", pgx.Identifier{name}.Sanitize())); err != nil {
+			tb.Errorf("failed to drop database %s: %v", name, err)
+		}
+	})
+	if err = w.Init(ctx); err != nil {
+		tb.Fatalf("failed to init wpgx: %v", err)
+	}
+	return db
+}
+
+func getServer() (*ServerConfig, error) {
+	serverOnce.Do(func() {
+		conf := &ServerConfig{}
+		if serverErr = envconfig.Process("WPGXTEST", conf); serverErr != nil {
+			return
+		}
+		switch {
+		case conf.PostgresBin != "":
+			serverErr = startServer(conf)
+		case !configured():
+			serverErr = probeServer(conf)
+		}
+		server = conf
+	})
+	return server, serverErr
+}
+
+// configured reports whether a WPGXTEST_* variable is set, in which case
+// an unreachable server fails the tests instead of skipping them.
+func configured() bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "WPGXTEST_") {
+			return true
+		}
+	}
+	return false
+}
+
+func probeServer(conf *ServerConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	conn, err := conf.connect(ctx, "postgres")
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNoServer, err)
+	}
+	return conn.Close(ctx)
+}
+
+func (c *ServerConfig) wpgxConfig(db string) *stumblewpgx.Config {
+	return &stumblewpgx.Config{
+		Username:        c.Username,
+		Password:        c.Password,
+		Host:            c.Host,
+		Port:            c.Port,
+		DBName:          db,
+		MaxConns:        10,
+		MaxConnLifetime: time.Hour,
+		MaxConnIdleTime: time.Minute,
+		AppName:         appName,
+		EnableTracing:   true,
+	}
+}
+
+// replicaConfig completes r with the settings of the database db.
+func (c *ServerConfig) replicaConfig(db string, r stumblewpgx.ReadReplicaConfig) stumblewpgx.ReadReplicaConfig {
+	config := c.wpgxConfig(db)
+	r.Username = cmp.Or(r.Username, config.Username)
+	r.Password = cmp.Or(r.Password, config.Password)
+	r.Host = cmp.Or(r.Host, config.Host)
+	r.Port = cmp.Or(r.Port, config.Port)
+	r.DBName = cmp.Or(r.DBName, config.DBName)
+	r.MaxConns = cmp.Or(r.MaxConns, config.MaxConns)
+	r.MaxConnLifetime = cmp.Or(r.MaxConnLifetime, config.MaxConnLifetime)
+	r.MaxConnIdleTime = cmp.Or(r.MaxConnIdleTime, config.MaxConnIdleTime)
+	return r
+}
+
+func (c *ServerConfig) connect(ctx context.Context, db string) (*pgx.Conn, error) {
+	connConfig, err := wpgx.ConnConfig(c.wpgxConfig(db))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.ConnectConfig(ctx, connConfig)
+}
+
+func adminExec(ctx context.Context, srv *ServerConfig, sql string) error {
+	conn, err := srv.connect(ctx, "postgres")
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, sql)
+	return err
+}
+
+// getTemplate returns the template database of migrations, creating it if
+// no test created it yet, in this process or in another one. A database
+// not marked as a template was left half-built by a process that died
+// while creating it, it is dropped and created again.
+func getTemplate(ctx context.Context, srv *ServerConfig, migrations fs.FS) (string, error) {
+	hash, err := hashFS(migrations)
+	if err != nil {
+		return "", err
+	}
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	if name, ok := templates[hash]; ok {
+		return name, nil
+	}
+	name := "wpgxtest_tpl_" + hash[:16]
+
+	conn, err := srv.connect(ctx, "postgres")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close(ctx)
+	// packages are tested in parallel processes sharing the server.
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock(hashtext($1))", name); err != nil {
+		return "", err
+	}
+	defer func() {
+		_, _ = conn.Exec(ctx, "SELECT pg_advisory_unlock(hashtext($1))", name)
+	}()
+	var complete bool
+	err = conn.QueryRow(ctx, "SELECT datistemplate FROM pg_database WHERE datname = $1", name).Scan(&complete)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+	if !complete {
+		drop := fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", pgx.Identifier{name}.Sanitize())
+		if _, err = conn.Exec(ctx, drop); err != nil {
+			return "", err
+		}
+		if err = createTemplate(ctx, srv, conn, name, migrations); err != nil {
+			_, _ = conn.Exec(ctx, drop)
+			return "", err
+		}
+	}
+	templates[hash] = name
+	return name, nil
+}
+
+func createTemplate(ctx context.Context, srv *ServerConfig, admin *pgx.Conn, name string, migrations fs.FS) error {
+	if _, err := admin.Exec(ctx, fmt.Sprintf("CREATE DATABASE %s TEMPLATE template0", pgx.Identifier{name}.Sanitize())); err != nil {
+		return err
+	}
+	w := wpgx.NewWPGXWithOptions(wpgx.WithConfig(srv.wpgxConfig(name)))
+	if err := w.Init(ctx); err != nil {
+		return err
+	}
+	err := migrate.NewMigrator(w, migrations, &migrate.Config{Table: "schema_migrations"}).Init(ctx)
+	// the template must have no connection left to be cloned.
+	if stopErr := w.Stop(ctx); err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		return err
+	}
+	// IS_TEMPLATE is set last, it marks the template as complete.
+	_, err = admin.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s WITH IS_TEMPLATE true ALLOW_CONNECTIONS false", pgx.Identifier{name}.Sanitize()))
+	return err
+}
+
+func hashFS(fsys fs.FS) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00", path, len(b))
+		_, _ = h.Write(b)
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+var invalidDBNameChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// dbName derives a unique database name from the test name.
+func dbName(testName string) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	suffix := "_" + hex.EncodeToString(b)
+	name := "wpgxtest_" + invalidDBNameChars.ReplaceAllString(strings.ToLower(testName), "_")
+	if len(name) > maxDBNameLen-len(suffix) {
+		name = name[:maxDBNameLen-len(suffix)]
+	}
+	return name + suffix
+}
@@ -0,0 +1,131 @@
+package wpgxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestDBName(t *testing.T) {
+	name := dbName("TestOrders/Create-With Items")
+	if !strings.HasPrefix(name, "wpgxtest_testorders_create_with_items_") {
+		t.Errorf("Unexpected name %s", name)
+	}
+	if other := dbName("TestOrders/Create-With Items"); other == name {
+		t.Errorf("Expected unique names, got %s twice", name)
+	}
+	long := dbName(strings.Repeat("TestVeryLongName", 10))
+	if len(long) > maxDBNameLen {
+		t.Errorf("Expected at most %d chars, got %d", maxDBNameLen, len(long))
+	}
+}
+
+func TestGoldenJSON(t *testing.T) {
+	GoldenJSON(t, "orders", []map[string]any{{"id": 1, "items": []string{"a", "b"}}})
+}
+
+func testServer(t *testing.T) *ServerConfig {
+	t.Helper()
+	srv, err := getServer()
+	if errors.Is(err, errNoServer) {
+		t.Skipf("skipping: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("failed to get postgres server: %v", err)
+	}
+	return srv
+}
+
+func databaseExists(t *testing.T, srv *ServerConfig, name string) (exists, template bool) {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := srv.connect(ctx, "postgres")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close(ctx)
+	err = conn.QueryRow(ctx, "SELECT datistemplate FROM pg_database WHERE datname = $1", name).Scan(&template)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false
+	}
+	if err != nil {
+		t.Fatalf("failed to look up database %s: %v", name, err)
+	}
+	return true, template
+}
+
+func TestNew(t *testing.T) {
+	srv := testServer(t)
+	migrations := fstest.MapFS{
+		"1_create_orders.up.sql": {Data: []byte("CREATE TABLE orders (id bigint PRIMARY KEY, item text NOT NULL);")},
+	}
+	ctx := context.Background()
+
+	var name string
+	t.Run("create", func(t *testing.T) {
+		db := New(t, WithMigrations(migrations))
+		name = db.Name
+		if _, err := db.WConn().WExec(ctx, "insert_order", "INSERT INTO orders (id, item) VALUES (1, 'a')"); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		if exists, _ := databaseExists(t, srv, name); !exists {
+			t.Errorf("Expected database %s to exist", name)
+		}
+	})
+	if exists, _ := databaseExists(t, srv, name); exists {
+		t.Errorf("Expected database %s to be dropped after the test", name)
+	}
+
+	// cloned from the same template, without the rows of the other test.
+	db := New(t, WithMigrations(migrations))
+	var count int
+	if err := db.WConn().WQueryRow(ctx, "count_orders", "SELECT count(*) FROM orders").Scan(&count); err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected an empty clone, got %d orders", count)
+	}
+}
+
+func TestGetTemplate_RebuildsHalfBuilt(t *testing.T) {
+	srv := testServer(t)
+	ctx := context.Background()
+	// unique migrations, for a template no other run created.
+	migrations := fstest.MapFS{
+		"1_create_items.up.sql": {Data: []byte(fmt.Sprintf("-- %s\nCREATE TABLE items (id bigint);", dbName(t.Name())))},
+	}
+	hash, err := hashFS(migrations)
+	if err != nil {
+		t.Fatalf("failed to hash migrations: %v", err)
+	}
+	name := "wpgxtest_tpl_" + hash[:16]
+	t.Cleanup(func() {
+		_ = adminExec(ctx, srv, fmt.Sprintf("ALTER DATABASE %s WITH IS_TEMPLATE false", pgx.Identifier{name}.Sanitize()))
+		_ = adminExec(ctx, srv, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", pgx.Identifier{name}.Sanitize()))
+	})
+	// as left by a process that died before migrating it.
+	if err = adminExec(ctx, srv, fmt.Sprintf("CREATE DATABASE %s", pgx.Identifier{name}.Sanitize())); err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	template, err := getTemplate(ctx, srv, migrations)
+	if err != nil {
+		t.Fatalf("getTemplate failed: %v", err)
+	}
+	if template != name {
+		t.Errorf("Expected template %s, got %s", name, template)
+	}
+	if _, isTemplate := databaseExists(t, srv, name); !isTemplate {
+		t.Errorf("Expected %s to be rebuilt as a template", name)
+	}
+
+	db := New(t, WithMigrations(migrations))
+	if _, err = db.WConn().WExec(ctx, "insert_item", "INSERT INTO items (id) VALUES (1)"); err != nil {
+		t.Errorf("Expected the migrations in the clone, got %v", err)
+	}
+}